@@ -1,7 +1,14 @@
 package ast
 
 import (
+	"fmt"
+	goast "go/ast"
+	"go/parser"
+	gotoken "go/token"
+	"io/fs"
+	"math/big"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/tzcl/monkey/token"
@@ -28,6 +35,27 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestLoopString(t *testing.T) {
+	ident := func(name string) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+	}
+	body := &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: ident("x")}}}
+
+	tests := []struct {
+		node     Node
+		expected string
+	}{
+		{&WhileStatement{Condition: ident("x"), Body: body}, "while (x) x"},
+		{&ForStatement{Variable: ident("x"), Iterable: ident("xs"), Body: body}, "for (x in xs) x"},
+	}
+
+	for _, tt := range tests {
+		if tt.node.String() != tt.expected {
+			t.Errorf("String() wrong. want=%q, got=%q", tt.expected, tt.node.String())
+		}
+	}
+}
 func TestModify(t *testing.T) {
 	one := func() Expression { return &IntegerLiteral{Value: 1} }
 	two := func() Expression { return &IntegerLiteral{Value: 2} }
@@ -78,6 +106,10 @@ func TestModify(t *testing.T) {
 			&PrefixExpression{Operator: "-", Right: one()},
 			&PrefixExpression{Operator: "-", Right: two()},
 		},
+		{
+			&AssignExpression{Name: &Identifier{Value: "x"}, Operator: "=", Value: one()},
+			&AssignExpression{Name: &Identifier{Value: "x"}, Operator: "=", Value: two()},
+		},
 		{
 			&IfExpression{
 				Condition: one(),
@@ -132,6 +164,44 @@ func TestModify(t *testing.T) {
 				},
 			},
 		},
+		{
+			&WhileStatement{
+				Condition: one(),
+				Body: &BlockStatement{
+					Statements: []Statement{&ExpressionStatement{Expression: one()}},
+				},
+			},
+			&WhileStatement{
+				Condition: two(),
+				Body: &BlockStatement{
+					Statements: []Statement{&ExpressionStatement{Expression: two()}},
+				},
+			},
+		},
+		{
+			&ForStatement{
+				Variable: &Identifier{Value: "x"},
+				Iterable: one(),
+				Body:     &BlockStatement{},
+			},
+			&ForStatement{
+				Variable: &Identifier{Value: "x"},
+				Iterable: two(),
+				Body:     &BlockStatement{},
+			},
+		},
+		{
+			&ArrayLiteral{Elements: []Expression{one(), one()}},
+			&ArrayLiteral{Elements: []Expression{two(), two()}},
+		},
+		{
+			&IndexExpression{Left: one(), Index: one()},
+			&IndexExpression{Left: two(), Index: two()},
+		},
+		{
+			&CallExpression{Function: one(), Arguments: []Expression{one(), one()}},
+			&CallExpression{Function: two(), Arguments: []Expression{two(), two()}},
+		},
 	}
 
 	for _, tt := range tests {
@@ -142,4 +212,353 @@ func TestModify(t *testing.T) {
 			t.Errorf("not equal. got=%#v, want=%#v", modified, tt.expected)
 		}
 	}
+
+	hashLiteral := &HashLiteral{
+		Pairs: map[Expression]Expression{
+			one(): one(),
+			one(): one(),
+		},
+	}
+
+	Modify(hashLiteral, turnOneIntoTwo)
+
+	for key, val := range hashLiteral.Pairs {
+		key, _ := key.(*IntegerLiteral)
+		if key.Value != 2 {
+			t.Errorf("value is not %d, got=%d", 2, key.Value)
+		}
+		val, _ := val.(*IntegerLiteral)
+		if val.Value != 2 {
+			t.Errorf("value is not %d, got=%d", 2, val.Value)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	one := func() Expression { return &IntegerLiteral{Value: 1} }
+
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{Name: ident("f"), Value: &FunctionLiteral{
+				Name:       "f",
+				Parameters: []*Identifier{ident("x")},
+				Body: &BlockStatement{Statements: []Statement{
+					&ReturnStatement{ReturnValue: &InfixExpression{Left: ident("x"), Operator: "+", Right: one()}},
+				}},
+			}},
+			&WhileStatement{Condition: &Boolean{Value: true}, Body: &BlockStatement{
+				Statements: []Statement{&BreakStatement{}, &ContinueStatement{}},
+			}},
+			&ForStatement{Variable: ident("x"), Iterable: &ArrayLiteral{Elements: []Expression{one()}}, Body: &BlockStatement{}},
+			&ExpressionStatement{Expression: &IfExpression{
+				Condition:   &PrefixExpression{Operator: "!", Right: &StringLiteral{Value: "s"}},
+				Consequence: &BlockStatement{},
+			}},
+			&ExpressionStatement{Expression: &CallExpression{
+				Function:  ident("f"),
+				Arguments: []Expression{&IndexExpression{Left: ident("a"), Index: &FloatLiteral{Value: 1.5}}},
+			}},
+			&ExpressionStatement{Expression: &AssignExpression{Name: ident("x"), Operator: "+=", Value: one()}},
+			&ExpressionStatement{Expression: &MacroLiteral{Parameters: []*Identifier{ident("m")}, Body: &BlockStatement{}}},
+		},
+	}
+
+	copied := Copy(program)
+	if !reflect.DeepEqual(copied, program) {
+		t.Fatalf("copy differs from original.\ngot=%s\nwant=%s", copied.String(), program.String())
+	}
+
+	// Changing the copy leaves the original alone
+	before := program.String()
+	Modify(copied, func(node Node) Node {
+		if integer, ok := node.(*IntegerLiteral); ok {
+			integer.Value = 2
+		}
+		if ident, ok := node.(*Identifier); ok {
+			ident.Value = "renamed"
+		}
+		return node
+	})
+	if copied.String() == before {
+		t.Fatalf("modifying the copy had no effect")
+	}
+	if program.String() != before {
+		t.Errorf("modifying the copy changed the original. got=%s", program.String())
+	}
+
+	// Hash keys are pointers, so DeepEqual can't compare hashes
+	key := one()
+	hash := &HashLiteral{Pairs: map[Expression]Expression{key: one()}}
+	copiedHash := Copy(hash).(*HashLiteral)
+	if copiedHash.String() != hash.String() {
+		t.Errorf("hash copy differs. got=%s, want=%s", copiedHash.String(), hash.String())
+	}
+	if _, ok := copiedHash.Pairs[key]; ok {
+		t.Errorf("hash copy shares its keys with the original")
+	}
+}
+
+// nodeSamples has a value of every node type, for tests that need to cover
+// them all. TestNodeSamples fails if a node type is missing.
+var nodeSamples = []Node{
+	&Program{},
+	&LetStatement{},
+	&ReturnStatement{},
+	&ExpressionStatement{},
+	&WhileStatement{},
+	&ForStatement{},
+	&BreakStatement{},
+	&ContinueStatement{},
+	&BlockStatement{},
+	&Identifier{},
+	&Boolean{},
+	&IntegerLiteral{},
+	&BigIntLiteral{},
+	&FloatLiteral{},
+	&StringLiteral{},
+	&PrefixExpression{},
+	&InfixExpression{},
+	&AssignExpression{},
+	&IfExpression{},
+	&FunctionLiteral{},
+	&CallExpression{},
+	&ArrayLiteral{},
+	&IndexExpression{},
+	&HashLiteral{},
+	&MacroLiteral{},
+}
+
+func TestNodeSamples(t *testing.T) {
+	fset := gotoken.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sampled := map[string]bool{}
+	for _, node := range nodeSamples {
+		sampled[reflect.TypeOf(node).Elem().Name()] = true
+	}
+
+	// Every type with a Pos method is a node
+	for _, file := range pkgs["ast"].Files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*goast.FuncDecl)
+			if !ok || fn.Recv == nil || fn.Name.Name != "Pos" {
+				continue
+			}
+
+			recv := fn.Recv.List[0].Type
+			if star, ok := recv.(*goast.StarExpr); ok {
+				recv = star.X
+			}
+			name := recv.(*goast.Ident).Name
+
+			if !sampled[name] {
+				t.Errorf("node type %s is missing from nodeSamples", name)
+			}
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	integer := func(value int64, offset int) Expression {
+		return &IntegerLiteral{
+			Token: token.Token{Literal: fmt.Sprint(value), Pos: token.Position{Offset: offset}},
+			Value: value,
+		}
+	}
+
+	// let x = f(1, {3: 4, 2: 5});
+	program := &Program{Statements: []Statement{
+		&LetStatement{Name: ident("x"), Value: &CallExpression{
+			Function: ident("f"),
+			Arguments: []Expression{
+				integer(1, 10),
+				&HashLiteral{Pairs: map[Expression]Expression{
+					integer(3, 14): integer(4, 17),
+					integer(2, 20): integer(5, 23),
+				}},
+			},
+		}},
+	}}
+
+	tests := []struct {
+		prune    func(Node) bool
+		expected string
+	}{
+		{
+			func(Node) bool { return false },
+			"Program LetStatement Identifier(x) end CallExpression Identifier(f) end " +
+				"IntegerLiteral(1) end HashLiteral IntegerLiteral(3) end IntegerLiteral(4) end " +
+				"IntegerLiteral(2) end IntegerLiteral(5) end end end end end",
+		},
+		{
+			func(node Node) bool { _, ok := node.(*HashLiteral); return ok },
+			"Program LetStatement Identifier(x) end CallExpression Identifier(f) end " +
+				"IntegerLiteral(1) end HashLiteral end end end",
+		},
+		{
+			func(node Node) bool { _, ok := node.(*LetStatement); return ok },
+			"Program LetStatement end",
+		},
+	}
+
+	for _, tt := range tests {
+		var trace []string
+		Inspect(program, func(node Node) bool {
+			switch node := node.(type) {
+			case nil:
+				trace = append(trace, "end")
+			case *Identifier, *IntegerLiteral:
+				trace = append(trace, fmt.Sprintf("%s(%s)", reflect.TypeOf(node).Elem().Name(), node))
+			default:
+				trace = append(trace, reflect.TypeOf(node).Elem().Name())
+			}
+			return !tt.prune(node)
+		})
+
+		if got := strings.Join(trace, " "); got != tt.expected {
+			t.Errorf("wrong trace.\nwant=%s\ngot= %s", tt.expected, got)
+		}
+	}
+}
+
+// TestModifyVisitsEveryChild fills every child of every node type with
+// markers and checks that Modify reaches all of them.
+func TestModifyVisitsEveryChild(t *testing.T) {
+	for _, sample := range nodeSamples {
+		node := markedNode(t, sample)
+		Modify(node.Interface().(Node), func(node Node) Node {
+			unmark(node)
+			return node
+		})
+
+		typ := node.Elem().Type()
+		for i := 0; i < typ.NumField(); i++ {
+			if hasMarker(node.Elem().Field(i)) {
+				t.Errorf("Modify doesn't visit %s.%s", typ.Name(), typ.Field(i).Name)
+			}
+		}
+	}
+}
+
+func TestWalkVisitsEveryChild(t *testing.T) {
+	for _, sample := range nodeSamples {
+		node := markedNode(t, sample)
+		Inspect(node.Interface().(Node), func(node Node) bool {
+			unmark(node)
+			return true
+		})
+
+		typ := node.Elem().Type()
+		for i := 0; i < typ.NumField(); i++ {
+			if hasMarker(node.Elem().Field(i)) {
+				t.Errorf("Walk doesn't visit %s.%s", typ.Name(), typ.Field(i).Name)
+			}
+		}
+	}
+}
+
+// markedNode returns a new node of the same type as sample, with every child
+// filled in with nodes that hasMarker recognises until they're unmarked.
+func markedNode(t *testing.T, sample Node) reflect.Value {
+	mark := func() Expression { return &IntegerLiteral{Value: 1} }
+	markIdent := func() *Identifier { return &Identifier{Value: "unvisited"} }
+	markBlock := func() *BlockStatement {
+		return &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: mark()}}}
+	}
+
+	fieldValues := map[reflect.Type]func() any{
+		reflect.TypeOf((*Expression)(nil)).Elem(): func() any { return mark() },
+		reflect.TypeOf((*Statement)(nil)).Elem(): func() any {
+			return Statement(&ExpressionStatement{Expression: mark()})
+		},
+		reflect.TypeOf(&Identifier{}):     func() any { return markIdent() },
+		reflect.TypeOf(&BlockStatement{}): func() any { return markBlock() },
+		reflect.TypeOf([]Expression{}):    func() any { return []Expression{mark(), mark()} },
+		reflect.TypeOf([]Statement{}):     func() any { return []Statement{&ExpressionStatement{Expression: mark()}} },
+		reflect.TypeOf([]*Identifier{}):   func() any { return []*Identifier{markIdent(), markIdent()} },
+		reflect.TypeOf(map[Expression]Expression{}): func() any {
+			return map[Expression]Expression{mark(): mark()}
+		},
+	}
+
+	// Fields that hold data rather than child nodes
+	leafTypes := map[reflect.Type]bool{
+		reflect.TypeOf(token.Token{}): true,
+		reflect.TypeOf(""):            true,
+		reflect.TypeOf(false):         true,
+		reflect.TypeOf(int64(0)):      true,
+		reflect.TypeOf(float64(0)):    true,
+		reflect.TypeOf(&big.Int{}):    true,
+	}
+
+	typ := reflect.TypeOf(sample).Elem()
+	node := reflect.New(typ)
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		switch {
+		case leafTypes[field.Type]:
+		case fieldValues[field.Type] != nil:
+			node.Elem().Field(i).Set(reflect.ValueOf(fieldValues[field.Type]()))
+		default:
+			t.Errorf("%s.%s has type %s, which this test doesn't know how to fill",
+				typ.Name(), field.Name, field.Type)
+		}
+	}
+
+	return node
+}
+
+func unmark(node Node) {
+	switch node := node.(type) {
+	case *IntegerLiteral:
+		node.Value = 2
+	case *Identifier:
+		node.Value = "visited"
+	}
+}
+
+// hasMarker reports whether v holds a node from markedNode that hasn't been
+// unmarked.
+func hasMarker(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return false
+		}
+		switch node := v.Interface().(type) {
+		case *IntegerLiteral:
+			return node.Value == 1
+		case *Identifier:
+			return node.Value == "unvisited"
+		}
+		return hasMarker(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if hasMarker(v.Field(i)) {
+				return true
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if hasMarker(v.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if hasMarker(iter.Key()) || hasMarker(iter.Value()) {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -0,0 +1,162 @@
+package ast
+
+import "sort"
+
+// A Visitor's Visit method is called for each node encountered by Walk. If the
+// result visitor w is not nil, Walk visits each of the children of node with
+// w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node in depth-first order, without
+// changing it. It starts by calling v.Visit(node); node must not be nil.
+// Children are visited in the order they appear in the source.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		walkStatements(v, node.Statements)
+
+	case *LetStatement:
+		walkIdentifier(v, node.Name)
+		walkExpression(v, node.Value)
+
+	case *ReturnStatement:
+		walkExpression(v, node.ReturnValue)
+
+	case *ExpressionStatement:
+		walkExpression(v, node.Expression)
+
+	case *WhileStatement:
+		walkExpression(v, node.Condition)
+		walkBlock(v, node.Body)
+
+	case *ForStatement:
+		walkIdentifier(v, node.Variable)
+		walkExpression(v, node.Iterable)
+		walkBlock(v, node.Body)
+
+	case *BlockStatement:
+		walkStatements(v, node.Statements)
+
+	case *PrefixExpression:
+		walkExpression(v, node.Right)
+
+	case *InfixExpression:
+		walkExpression(v, node.Left)
+		walkExpression(v, node.Right)
+
+	case *AssignExpression:
+		walkIdentifier(v, node.Name)
+		walkExpression(v, node.Value)
+
+	case *Identifier:
+		walkExpression(v, node.Unquote)
+
+	case *IfExpression:
+		walkExpression(v, node.Condition)
+		walkBlock(v, node.Consequence)
+		walkBlock(v, node.Alternative)
+
+	case *FunctionLiteral:
+		walkIdentifiers(v, node.Parameters)
+		walkBlock(v, node.Body)
+
+	case *MacroLiteral:
+		walkIdentifiers(v, node.Parameters)
+		walkBlock(v, node.Body)
+
+	case *CallExpression:
+		walkExpression(v, node.Function)
+		walkExpressions(v, node.Arguments)
+
+	case *ArrayLiteral:
+		walkExpressions(v, node.Elements)
+
+	case *IndexExpression:
+		walkExpression(v, node.Left)
+		walkExpression(v, node.Index)
+
+	case *HashLiteral:
+		// Pairs are kept in a map, so sort them back into source order. Keys
+		// of synthesised hashes have no position, fall back on their text.
+		keys := make([]Expression, 0, len(node.Pairs))
+		for key := range node.Pairs {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if a, b := keys[i].Pos().Offset, keys[j].Pos().Offset; a != b {
+				return a < b
+			}
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, key := range keys {
+			walkExpression(v, key)
+			walkExpression(v, node.Pairs[key])
+		}
+	}
+
+	v.Visit(nil)
+}
+
+// Optional children are nil, so only walk the ones that are there
+
+func walkExpression(v Visitor, exp Expression) {
+	if exp != nil {
+		Walk(v, exp)
+	}
+}
+
+func walkExpressions(v Visitor, exps []Expression) {
+	for _, exp := range exps {
+		walkExpression(v, exp)
+	}
+}
+
+func walkStatements(v Visitor, stmts []Statement) {
+	for _, stmt := range stmts {
+		if stmt != nil {
+			Walk(v, stmt)
+		}
+	}
+}
+
+func walkBlock(v Visitor, block *BlockStatement) {
+	if block != nil {
+		Walk(v, block)
+	}
+}
+
+func walkIdentifier(v Visitor, ident *Identifier) {
+	if ident != nil {
+		Walk(v, ident)
+	}
+}
+
+func walkIdentifiers(v Visitor, idents []*Identifier) {
+	for _, ident := range idents {
+		walkIdentifier(v, ident)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree rooted at node in depth-first order. It starts
+// by calling f(node); node must not be nil. If f returns true, Inspect calls f
+// for each of the children of node, followed by a call of f(nil) once they
+// are done. Returning false skips the children.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,811 @@
+// Package compiler compiles a Monkey AST to bytecode for the vm package.
+package compiler
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/tzcl/monkey/ast"
+	"github.com/tzcl/monkey/code"
+	"github.com/tzcl/monkey/object"
+)
+
+type Compiler struct {
+	constants []object.Object
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+}
+
+// CompilationScope holds the instructions of the function being compiled.
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	loops []*loopContext // enclosing loops, innermost last
+}
+
+// loopContext tracks where break and continue jump to in the loop being
+// compiled. The end of the loop isn't known until its body is compiled, so
+// breaks are patched afterwards.
+type loopContext struct {
+	start  int
+	breaks []int
+
+	stackSlots int // values the loop keeps on the stack, which break drops
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	NumLocals    int // locals of the main program, defined by its loops
+}
+
+func New() *Compiler {
+	mainScope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: NewGlobalSymbolTable(),
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// NewWithState returns a compiler that carries on from an earlier one, so
+// globals and constants survive between REPL inputs.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	// Locals of earlier programs don't outlive them
+	s.numLocals = 0
+	compiler.constants = constants
+	return compiler
+}
+
+// NewGlobalSymbolTable returns a symbol table with the builtins defined, ready
+// to be shared across calls to NewWithState.
+func NewGlobalSymbolTable() *SymbolTable {
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	return symbolTable
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			err := c.Compile(s)
+			if err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		err := c.Compile(node.Expression)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			err := c.Compile(s)
+			if err != nil {
+				return err
+			}
+		}
+
+	case *ast.WhileStatement:
+		return c.compileWhileStatement(node)
+
+	case *ast.ForStatement:
+		return c.compileForStatement(node)
+
+	case *ast.BreakStatement:
+		loop, err := c.currentLoop(node)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < loop.stackSlots; i++ {
+			c.emit(code.OpPop)
+		}
+		loop.breaks = append(loop.breaks, c.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		loop, err := c.currentLoop(node)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loop.start)
+
+	case *ast.LetStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		c.defineSymbol(node.Name.Value)
+
+	case *ast.ReturnStatement:
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpReturnValue)
+
+	case *ast.PrefixExpression:
+		err := c.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+
+		// NOTE: there is no OpLessThan, we swap the operands instead
+		if node.Operator == "<" || node.Operator == "<=" {
+			err := c.Compile(node.Right)
+			if err != nil {
+				return err
+			}
+
+			err = c.Compile(node.Left)
+			if err != nil {
+				return err
+			}
+
+			if node.Operator == "<" {
+				c.emit(code.OpGreaterThan)
+			} else {
+				c.emit(code.OpGreaterThanOrEqual)
+			}
+			return nil
+		}
+
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "+":
+			c.emit(code.OpAdd)
+		case "-":
+			c.emit(code.OpSub)
+		case "*":
+			c.emit(code.OpMul)
+		case "/":
+			c.emit(code.OpDiv)
+		case ">":
+			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
+		case "==":
+			c.emit(code.OpEqual)
+		case "!=":
+			c.emit(code.OpNotEqual)
+		default:
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+
+	case *ast.IfExpression:
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		// Emit with a bogus offset, we patch it once the consequence is compiled
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		err = c.Compile(node.Consequence)
+		if err != nil {
+			return err
+		}
+
+		if c.lastInstructionIs(code.OpPop) {
+			c.removeLastPop()
+		} else {
+			c.emit(code.OpNull)
+		}
+
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		afterConsequencePos := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else {
+			err := c.Compile(node.Alternative)
+			if err != nil {
+				return err
+			}
+
+			if c.lastInstructionIs(code.OpPop) {
+				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
+			}
+		}
+
+		afterAlternativePos := len(c.currentInstructions())
+		c.changeOperand(jumpPos, afterAlternativePos)
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			return fmt.Errorf("%s: identifier not found: %s", node.Pos(), node.Value)
+		}
+
+		c.loadSymbol(symbol)
+
+	case *ast.IntegerLiteral:
+		integer := &object.Integer{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.BigIntLiteral:
+		integer := &object.BigInt{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		keys := []ast.Expression{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		// Go doesn't guarantee map ordering, sort so the output is deterministic
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, k := range keys {
+			err := c.Compile(k)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Pairs[k])
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Index)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpIndex)
+
+	case *ast.FunctionLiteral:
+		c.enterScope()
+		c.symbolTable.boxed = boxedNames(node.Body)
+
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, p := range node.Parameters {
+			symbol := c.symbolTable.Define(p.Value)
+			if symbol.Boxed {
+				c.emit(code.OpGetLocal, symbol.Index)
+				c.emit(code.OpNewCell)
+				c.emit(code.OpSetLocal, symbol.Index)
+			}
+		}
+
+		err := c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+
+		// Implicitly return the last expression
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions
+		instructions := c.leaveScope()
+
+		// Boxed variables are captured as their cells
+		for _, s := range freeSymbols {
+			c.loadSlot(s)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+		}
+
+		fnIndex := c.addConstant(compiledFn)
+		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	case *ast.CallExpression:
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range node.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpCall, len(node.Arguments))
+
+	default:
+		return fmt.Errorf("%s: %T is not supported by the compiler", node.Pos(), node)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	start := len(c.currentInstructions())
+
+	err := c.Compile(node.Condition)
+	if err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	err = c.compileLoopBody(&loopContext{start: start}, node.Body)
+	if err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	c.leaveLoop()
+
+	return nil
+}
+
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	err := c.Compile(node.Iterable)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpIterable)
+	c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: 0}))
+
+	start := c.emit(code.OpIterNext, 9999)
+
+	// Like in the evaluator, the variable and the names the body defines are
+	// only in scope in the body. They're locals even outside functions, and
+	// each iteration stores new values, or new cells if they're boxed, so
+	// closures created in the body capture that iteration's element.
+	c.enterBlock(node.Body)
+	c.defineSymbol(node.Variable.Value)
+
+	err = c.compileLoopBody(&loopContext{start: start, stackSlots: 2}, node.Body)
+	if err != nil {
+		return err
+	}
+
+	c.leaveBlock()
+
+	c.changeOperand(start, len(c.currentInstructions()))
+	c.leaveLoop()
+
+	return nil
+}
+
+// compileLoopBody compiles body followed by a jump back to the start of the
+// loop. The caller must patch its exit jump and then call leaveLoop.
+func (c *Compiler) compileLoopBody(loop *loopContext, body *ast.BlockStatement) error {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, loop)
+
+	err := c.Compile(body)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpJump, loop.start)
+	return nil
+}
+
+// leaveLoop patches the breaks of the innermost loop to jump to the current
+// position, which should be the end of the loop.
+func (c *Compiler) leaveLoop() {
+	scope := &c.scopes[c.scopeIndex]
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, pos := range loop.breaks {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+}
+
+func (c *Compiler) currentLoop(node ast.Node) (*loopContext, error) {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil, fmt.Errorf("%s: %s outside loop", node.Pos(), node.TokenLiteral())
+	}
+	return loops[len(loops)-1], nil
+}
+
+var compoundAssignOps = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+// compileAssignExpression stores the new value and leaves a copy of it on the
+// stack, since assignment is an expression. Free variables can only be
+// assigned to if they are boxed, which boxedNames makes sure of for every
+// variable that is.
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+	if !ok || symbol.Scope == BuiltinScope {
+		return fmt.Errorf("%s: assignment to undeclared variable: %s", node.Pos(), node.Name.Value)
+	}
+	if symbol.Scope == FunctionScope || symbol.Scope == FreeScope && !symbol.Boxed {
+		return fmt.Errorf("%s: cannot assign to %s in the vm", node.Pos(), node.Name.Value)
+	}
+
+	if node.Operator != "=" {
+		c.loadSymbol(symbol)
+	}
+
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	if node.Operator != "=" {
+		op, ok := compoundAssignOps[node.Operator]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+		c.emit(op)
+	}
+
+	switch {
+	case symbol.Scope == GlobalScope:
+		c.emit(code.OpSetGlobal, symbol.Index)
+	case symbol.Boxed:
+		c.loadSlot(symbol)
+		c.emit(code.OpStoreCell)
+	default:
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+	c.loadSymbol(symbol)
+
+	return nil
+}
+
+// defineSymbol defines name and stores the value on top of the stack in it.
+func (c *Compiler) defineSymbol(name string) {
+	symbol := c.symbolTable.Define(name)
+
+	switch {
+	case symbol.Scope == GlobalScope:
+		c.emit(code.OpSetGlobal, symbol.Index)
+	case symbol.Boxed:
+		c.emit(code.OpNewCell)
+		c.emit(code.OpSetLocal, symbol.Index)
+	default:
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+}
+
+// boxedNames returns the names that closures inside body refer to and that
+// are assigned to somewhere in body. Locals with these names are kept in
+// cells, which body and its closures share, so that they all see assignments.
+// Names are matched without regard to scope, which can only box more locals
+// than necessary.
+func boxedNames(body *ast.BlockStatement) map[string]bool {
+	finder := &captureFinder{captured: map[string]bool{}, assigned: map[string]bool{}}
+	ast.Walk(finder, body)
+
+	boxed := map[string]bool{}
+	for name := range finder.captured {
+		if finder.assigned[name] {
+			boxed[name] = true
+		}
+	}
+	return boxed
+}
+
+// captureFinder collects the names used inside nested function literals and
+// the names assigned to anywhere.
+type captureFinder struct {
+	nested   bool
+	captured map[string]bool
+	assigned map[string]bool
+}
+
+func (f *captureFinder) Visit(node ast.Node) ast.Visitor {
+	switch node := node.(type) {
+	case *ast.FunctionLiteral:
+		if !f.nested {
+			return &captureFinder{nested: true, captured: f.captured, assigned: f.assigned}
+		}
+	case *ast.Identifier:
+		if f.nested {
+			f.captured[node.Value] = true
+		}
+	case *ast.AssignExpression:
+		f.assigned[node.Name.Value] = true
+	}
+	return f
+}
+
+// compileLogicalExpression compiles && and || so the right operand is skipped
+// when the left one already decides the result. Both leave a boolean behind.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if node.Operator == "||" {
+		c.emit(code.OpTrue)
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		err := c.compileTruthiness(node.Right)
+		if err != nil {
+			return err
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	err = c.compileTruthiness(node.Right)
+	if err != nil {
+		return err
+	}
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	c.emit(code.OpFalse)
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileTruthiness compiles node and converts its value to a boolean.
+func (c *Compiler) compileTruthiness(node ast.Expression) error {
+	err := c.Compile(node)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpBang)
+	c.emit(code.OpBang)
+	return nil
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		NumLocals:    c.symbolTable.numLocals,
+	}
+}
+
+// SymbolTable returns the compiler's symbol table so that it can be handed to
+// NewWithState.
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	new := old[:last.Position]
+
+	c.scopes[c.scopeIndex].instructions = new
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+// changeOperand replaces the instruction at pos, which must have the same
+// opcode and width, with one using the given operand.
+func (c *Compiler) changeOperand(pos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[pos])
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(pos, newInstruction)
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+// enterBlock starts a scope for the names defined in body, whose locals are
+// stored in the current frame.
+func (c *Compiler) enterBlock(body *ast.BlockStatement) {
+	block := NewBlockSymbolTable(c.symbolTable)
+	if c.symbolTable.Outer == nil {
+		// Outside functions nothing has been boxed yet
+		block.boxed = boxedNames(body)
+	}
+	c.symbolTable = block
+}
+
+func (c *Compiler) leaveBlock() {
+	c.symbolTable = c.symbolTable.Outer
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	c.loadSlot(s)
+	if s.Boxed {
+		c.emit(code.OpLoadCell)
+	}
+}
+
+// loadSlot pushes what is stored for s, which is its cell if s is boxed.
+func (c *Compiler) loadSlot(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
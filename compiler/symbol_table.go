@@ -0,0 +1,168 @@
+package compiler
+
+import "sort"
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+
+	// Boxed symbols are stored in a cell, see boxedNames
+	Boxed bool
+}
+
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+
+	// numLocals counts the locals of the main program, which only blocks
+	// define, in the global table
+	numLocals int
+
+	// Block tables hold the names defined in a loop body. They go out of
+	// scope with the block, but their locals live in the enclosing frame.
+	block bool
+
+	// FreeSymbols holds the original symbols (as resolved in an enclosing
+	// scope) of every free variable referenced in this scope
+	FreeSymbols []Symbol
+
+	// boxed holds the names of locals to define as boxed
+	boxed map[string]bool
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := make(map[string]Symbol)
+	free := []Symbol{}
+	return &SymbolTable{store: s, FreeSymbols: free}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// NewBlockSymbolTable returns a table for the names defined in a block of
+// outer's scope.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.block = true
+	s.boxed = outer.boxed
+	return s
+}
+
+func (s *SymbolTable) Define(name string) Symbol {
+	var symbol Symbol
+	if s.Outer == nil {
+		symbol = Symbol{Name: name, Scope: GlobalScope, Index: s.numDefinitions}
+		s.numDefinitions++
+	} else {
+		symbol = Symbol{Name: name, Scope: LocalScope, Index: s.newLocal()}
+		symbol.Boxed = s.boxed[name]
+	}
+
+	s.store[name] = symbol
+	return symbol
+}
+
+// newLocal allocates a slot for a local in the frame s is compiled into.
+func (s *SymbolTable) newLocal() int {
+	switch {
+	case s.block:
+		return s.Outer.newLocal()
+	case s.Outer == nil:
+		s.numLocals++
+		return s.numLocals - 1
+	default:
+		s.numDefinitions++
+		return s.numDefinitions - 1
+	}
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName binds the name of the function currently being compiled
+// so it can refer to itself without capturing itself as a free variable.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Boxed: original.Boxed}
+	symbol.Scope = FreeScope
+
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Resolve looks name up through the enclosing scopes. Locals of an enclosing
+// function are turned into free variables of this scope on the way back down,
+// unless this is a block, which shares the frame of its enclosing scope.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	if !ok && s.Outer != nil {
+		obj, ok = s.Outer.Resolve(name)
+		if !ok {
+			return obj, ok
+		}
+
+		if s.block || obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
+
+		free := s.defineFree(obj)
+		return free, true
+	}
+	return obj, ok
+}
+
+// Copy returns a copy of the table that names can be defined in without
+// affecting s.
+func (s *SymbolTable) Copy() *SymbolTable {
+	store := make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		store[name] = symbol
+	}
+
+	return &SymbolTable{
+		Outer:          s.Outer,
+		store:          store,
+		numDefinitions: s.numDefinitions,
+		numLocals:      s.numLocals,
+		block:          s.block,
+		FreeSymbols:    append([]Symbol{}, s.FreeSymbols...),
+		boxed:          s.boxed,
+	}
+}
+
+// Symbols returns the symbols defined directly in this table, ordered by
+// name.
+func (s *SymbolTable) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(s.store))
+	for _, symbol := range s.store {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Name < symbols[j].Name })
+	return symbols
+}
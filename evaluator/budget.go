@@ -0,0 +1,111 @@
+package evaluator
+
+import (
+	"context"
+
+	"github.com/tzcl/monkey/ast"
+	"github.com/tzcl/monkey/object"
+)
+
+// Messages of the errors an evaluation started by EvalContext stops with when
+// it runs out of budget.
+const (
+	CancelledMessage   = "execution cancelled"
+	StepLimitMessage   = "step limit exceeded"
+	MemoryLimitMessage = "memory limit exceeded"
+)
+
+// Limits bounds an evaluation started by EvalContext. The zero value doesn't
+// limit anything.
+type Limits struct {
+	// Steps is the most nodes that may be evaluated, if positive.
+	Steps int
+
+	// Memory is the most bytes of objects and environments that may be
+	// allocated, if positive. Memory is counted as it is allocated and never
+	// given back, so long running scripts need more than they ever hold at
+	// once.
+	Memory int
+
+	// CallDepth is how deeply function calls may nest, if positive, and
+	// DefaultCallDepth otherwise. Calls can't nest without bound, as each
+	// one that isn't a tail call costs Go stack.
+	CallDepth int
+}
+
+// EvalContext is Eval for code that might not terminate or might allocate
+// without bound. Evaluation stops with an error once ctx is done or once one
+// of the limits is exceeded.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits Limits) object.Object {
+	outer := env.Budget()
+	env.SetBudget(NewBudget(ctx, limits))
+	defer env.SetBudget(outer)
+
+	return Eval(node, env)
+}
+
+// NewBudget returns the budget of an evaluation that stops once ctx is done
+// or once one of the limits is exceeded. Setting it on more than one
+// environment, like the ones macros are expanded in, makes them all spend
+// from it.
+func NewBudget(ctx context.Context, limits Limits) *object.Budget {
+	budget := &object.Budget{Context: ctx, Steps: -1, Memory: -1, CallDepth: limits.CallDepth}
+	if limits.Steps > 0 {
+		budget.Steps = limits.Steps
+	}
+	if limits.Memory > 0 {
+		budget.Memory = limits.Memory
+	}
+	return budget
+}
+
+// spend takes a step out of budget, returning an error if there's nothing
+// left to take.
+func spend(budget *object.Budget) *object.Error {
+	select {
+	case <-budget.Context.Done():
+		return newError(CancelledMessage)
+	default:
+	}
+
+	switch {
+	case budget.Steps == 0:
+		return newError(StepLimitMessage)
+	case budget.Steps > 0:
+		budget.Steps--
+	}
+
+	return nil
+}
+
+// allocate takes size bytes out of the budget of the evaluation running in
+// env, returning an error if there isn't enough left.
+func allocate(env *object.Environment, size int) *object.Error {
+	budget := env.Budget()
+	if budget == nil || budget.Memory < 0 {
+		return nil
+	}
+
+	if size > budget.Memory {
+		budget.Memory = 0
+		return newError(MemoryLimitMessage)
+	}
+	budget.Memory -= size
+
+	return nil
+}
+
+// allocates reports whether evaluating node creates a new object, rather than
+// returning one that already exists.
+func allocates(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral, *ast.BigIntLiteral, *ast.FloatLiteral, *ast.StringLiteral,
+		*ast.PrefixExpression, *ast.InfixExpression,
+		*ast.ArrayLiteral, *ast.HashLiteral, *ast.FunctionLiteral:
+		return true
+	case *ast.AssignExpression:
+		return node.Operator != "="
+	default:
+		return false
+	}
+}
@@ -0,0 +1,28 @@
+package evaluator
+
+import (
+	"github.com/tzcl/monkey/object"
+)
+
+// builtins are consulted after the environment chain, so scripts are free to
+// shadow them with their own bindings.
+var builtins = map[string]*object.Builtin{}
+
+func init() {
+	for _, def := range object.Builtins {
+		builtins[def.Name] = def.Builtin
+	}
+
+	// Only useful to macros, so the VM doesn't need it
+	builtins["gensym"] = &object.Builtin{Fn: gensym}
+}
+
+// RegisterBuiltin makes fn available under name to every program run by the
+// evaluator. Registering an existing name replaces it.
+//
+// It isn't safe to call while programs are running, so call it during
+// initialisation, e.g. from an init function. The compiler and VM only know
+// the builtins in object.Builtins, so programs run with them won't see fn.
+func RegisterBuiltin(name string, fn object.BuiltinFunction) {
+	builtins[name] = &object.Builtin{Fn: fn}
+}
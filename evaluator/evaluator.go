@@ -2,6 +2,7 @@ package evaluator
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/tzcl/monkey/ast"
 	"github.com/tzcl/monkey/object"
@@ -9,12 +10,40 @@ import (
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if budget := env.Budget(); budget != nil {
+		if err := spend(budget); err != nil {
+			err.Pos = node.Pos()
+			return err
+		}
+	}
+
+	result := eval(node, env)
+
+	if allocates(node) && !isError(result) {
+		if err := allocate(env, object.SizeOf(result)); err != nil {
+			result = err
+		}
+	}
+
+	// Errors bubble up through every enclosing node, so only the innermost
+	// node (the first to see the error) gets to stamp its position on it.
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
@@ -24,11 +53,19 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.BlockStatement:
 		return evalBlockStatements(node.Statements, env)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := evalTail(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
 		if isError(val) {
@@ -39,6 +76,12 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	// Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.BigIntLiteral:
+		return &object.BigInt{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}
 	case *ast.Boolean:
 		return booleanReference(node.Value)
 	case *ast.PrefixExpression:
@@ -48,6 +91,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -59,6 +106,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 
 		return evalInfixExpression(node.Operator, left, right)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.Identifier:
@@ -66,32 +115,59 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Name: node.Name, Parameters: params, Env: env, Body: body}
 	case *ast.CallExpression:
 		if node.Function.TokenLiteral() == "quote" {
+			if len(node.Arguments) != 1 {
+				return newError("wrong number of arguments to quote: want=1, got=%d", len(node.Arguments))
+			}
 			return quote(node.Arguments[0], env)
 		}
 
-		fn := Eval(node.Function, env)
-		if isError(fn) {
-			return fn
+		fn, args, err := evalCall(node, env)
+		if err != nil {
+			return err
+		}
+
+		return applyFunction(fn, args, env, node.Pos())
+	case *ast.ArrayLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return &object.Array{Elements: elements}
+	case *ast.IndexExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
 		}
 
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
+		index := Eval(node.Index, env)
+		if isError(index) {
+			return index
 		}
 
-		return applyFunction(fn, args)
+		return evalIndexExpression(left, index)
+	case *ast.HashLiteral:
+		return evalHashLiteral(node, env)
 	}
 
 	return nil
 }
 
+// DefineMacros adds the macros defined by top-level let statements in program
+// to env and removes their definitions from program. Macros defined inside
+// blocks are only visible in their block, so ExpandMacros defines those.
 func DefineMacros(program *ast.Program, env *object.Environment) {
+	program.Statements = defineMacros(program.Statements, env)
+}
+
+// defineMacros adds the macros defined in stmts to env, returning stmts
+// without their definitions.
+func defineMacros(stmts []ast.Statement, env *object.Environment) []ast.Statement {
 	definitions := []int{}
 
-	for i, statement := range program.Statements {
+	for i, statement := range stmts {
 		if isMacroDefinition(statement) {
 			addMacro(statement, env)
 			definitions = append(definitions, i)
@@ -100,39 +176,165 @@ func DefineMacros(program *ast.Program, env *object.Environment) {
 
 	for i := len(definitions) - 1; i >= 0; i = i - 1 {
 		definitionIndex := definitions[i]
-		program.Statements = append(
-			program.Statements[:definitionIndex],
-			program.Statements[definitionIndex+1:]...,
+		stmts = append(
+			stmts[:definitionIndex],
+			stmts[definitionIndex+1:]...,
 		)
 	}
+
+	return stmts
+}
+
+// MacroError is a macro call that couldn't be expanded.
+type MacroError struct {
+	Macro  string         // name the macro was called by
+	Pos    token.Position // where the macro was called
+	Reason string
 }
 
-func ExpandMacros(program *ast.Program, env *object.Environment) ast.Node {
-	return ast.Modify(program, func(node ast.Node) ast.Node {
+func (e *MacroError) Error() string {
+	return fmt.Sprintf("%s: cannot expand macro %s: %s", e.Pos, e.Macro, e.Reason)
+}
+
+// ExpandMacros replaces every call to a macro defined in env, or in a block
+// around the call, with the code the macro returns. Macro calls in that code
+// are expanded too, up to MaxMacroDepth levels deep. Calls that can't be
+// expanded, including ones that would expand into themselves forever, are left
+// as they are and reported in the returned errors.
+func ExpandMacros(program *ast.Program, env *object.Environment) (ast.Node, []*MacroError) {
+	return expandMacros(program, env, false)
+}
+
+// ExpandMacrosHygienic is ExpandMacros for hygienic macros. Names bound by the
+// code a macro returns are renamed, so they can't capture or be captured by
+// names in the arguments the macro was called with. The catch is that macros
+// can no longer define names for the code around their call.
+func ExpandMacrosHygienic(program *ast.Program, env *object.Environment) (ast.Node, []*MacroError) {
+	return expandMacros(program, env, true)
+}
+
+// MaxMacroDepth is how many times a macro call may expand into code that
+// calls another macro before expansion gives up on it.
+var MaxMacroDepth = 100
+
+func expandMacros(program *ast.Program, env *object.Environment, hygienic bool) (ast.Node, []*MacroError) {
+	x := &macroExpander{hygienic: hygienic, failed: map[*ast.CallExpression]bool{}}
+	return x.expand(program, env, nil), x.errors
+}
+
+type macroExpander struct {
+	hygienic bool
+	errors   []*MacroError
+
+	// Calls that couldn't be expanded, which could be visited again if they
+	// were passed to another macro
+	failed map[*ast.CallExpression]bool
+}
+
+// expand replaces the macro calls in node until none are left. chain holds
+// the calls whose expansion node came from, outermost first.
+func (x *macroExpander) expand(node ast.Node, env *object.Environment, chain []string) ast.Node {
+	scope := &macroScope{env: env, calls: map[*ast.CallExpression]*object.Environment{}}
+	ast.Walk(scope, node)
+
+	return ast.Modify(node, func(node ast.Node) ast.Node {
 		callExpression, ok := node.(*ast.CallExpression)
-		if !ok {
+		if !ok || x.failed[callExpression] {
 			return node
 		}
 
+		env := scope.calls[callExpression]
 		macro, ok := isMacroCall(callExpression, env)
 		if !ok {
 			return node
 		}
 
-		args := quoteArgs(callExpression)
-		evalEnv := extendMacroEnv(macro, args)
+		call := callExpression.String()
 
-		evaluated := Eval(macro.Body, evalEnv)
+		var expansion ast.Node
+		reason := ""
+		if cycle := findCall(chain, call); cycle >= 0 {
+			reason = "expansion cycle: " + strings.Join(append(chain[cycle:], call), " -> ")
+		} else if len(chain) >= MaxMacroDepth {
+			reason = fmt.Sprintf("maximum macro expansion depth of %d exceeded", MaxMacroDepth)
+		} else {
+			expansion, reason = expandMacro(macro, callExpression)
+		}
 
-		quote, ok := evaluated.(*object.Quote)
-		if !ok {
-			panic("we only support returning AST-nodes from macros")
+		if reason != "" {
+			x.failed[callExpression] = true
+			x.errors = append(x.errors, &MacroError{
+				Macro:  callExpression.Function.String(),
+				Pos:    callExpression.Pos(),
+				Reason: reason,
+			})
+			return node
 		}
 
-		return quote.Node
+		if x.hygienic {
+			renameBindings(expansion, callExpression.Arguments)
+		}
+
+		// The expansion can call macros itself. Its arguments were expanded
+		// before the call was, so this only finds calls from the template.
+		return x.expand(expansion, env, append(chain[:len(chain):len(chain)], call))
 	})
 }
 
+// findCall returns the index of call in chain, or -1 if it isn't there.
+func findCall(chain []string, call string) int {
+	for i, c := range chain {
+		if c == call {
+			return i
+		}
+	}
+	return -1
+}
+
+// macroScope records the macro environment each call in a tree is in. Every
+// block gets an environment of its own, holding the macros defined in it.
+type macroScope struct {
+	env   *object.Environment
+	calls map[*ast.CallExpression]*object.Environment
+}
+
+func (s *macroScope) Visit(node ast.Node) ast.Visitor {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		env := object.NewEnclosedEnvironment(s.env)
+		node.Statements = defineMacros(node.Statements, env)
+		return &macroScope{env: env, calls: s.calls}
+	case *ast.CallExpression:
+		s.calls[node] = s.env
+	}
+	return s
+}
+
+// expandMacro evaluates a call to macro, returning the code it expands to or
+// the reason it can't be expanded.
+func expandMacro(macro *object.Macro, call *ast.CallExpression) (ast.Node, string) {
+	if len(call.Arguments) != len(macro.Parameters) {
+		return nil, fmt.Sprintf("wrong number of arguments: want=%d, got=%d",
+			len(macro.Parameters), len(call.Arguments))
+	}
+
+	args := quoteArgs(call)
+	evalEnv := extendMacroEnv(macro, args)
+
+	// A return in the body may hand back a tail call, e.g. to a helper that
+	// builds the quote, so finish it before checking what came back
+	evaluated := resolveTailCall(unwrapReturnValue(Eval(macro.Body, evalEnv)), evalEnv)
+
+	switch evaluated := evaluated.(type) {
+	case *object.Quote:
+		return evaluated.Node, ""
+	case *object.Error:
+		return nil, evaluated.Error()
+	default:
+		return nil, fmt.Sprintf("macro returned %s, not a quoted AST node", evaluated.Type())
+	}
+}
+
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
@@ -141,7 +343,7 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
-			return result.Value
+			return resolveTailCall(result.Value, env)
 		case *object.Error:
 			return result
 		}
@@ -156,17 +358,89 @@ func evalBlockStatements(stmts []ast.Statement, env *object.Environment) object.
 	for _, stmt := range stmts {
 		result = Eval(stmt, env)
 
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERR_OBJ {
-				return result
-			}
+		if interruptsBlock(result) {
+			return result
 		}
 	}
 
 	return result
 }
 
+// interruptsBlock reports whether result stops the rest of a block from
+// running.
+func interruptsBlock(result object.Object) bool {
+	if result == nil {
+		return false
+	}
+
+	rt := result.Type()
+	return rt == object.RETURN_VALUE_OBJ || rt == object.ERR_OBJ ||
+		rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ
+}
+
+// Loops run as Go loops rather than recursing, so they can run for any
+// number of iterations. Like let, they don't produce a value.
+
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		if !isTruthy(condition) {
+			return nil
+		}
+
+		result := Eval(ws.Body, env)
+		if done, result := loopResult(result); done {
+			return result
+		}
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	elements, ok := object.Elements(iterable)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+
+	for _, element := range elements {
+		// Each iteration gets its own scope, so closures created in the body
+		// capture that iteration's element
+		if err := allocate(env, object.EnvironmentSize(1)); err != nil {
+			return err
+		}
+		loopEnv := object.NewEnclosedEnvironment(env)
+		loopEnv.Set(fs.Variable.Value, element)
+
+		result := Eval(fs.Body, loopEnv)
+		if done, result := loopResult(result); done {
+			return result
+		}
+	}
+
+	return nil
+}
+
+// loopResult reports whether the result of a loop body ends the loop, and if
+// so what the loop should evaluate to.
+func loopResult(result object.Object) (bool, object.Object) {
+	switch result := result.(type) {
+	case *object.Break:
+		return true, nil
+	case *object.ReturnValue, *object.Error:
+		return true, result
+	default:
+		return false, nil
+	}
+}
+
 func booleanReference(input bool) *object.Boolean {
 	if input {
 		return TRUE
@@ -199,20 +473,73 @@ func evalNegationOperator(right object.Object) object.Object {
 }
 
 func evalNegativeOperator(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer, *object.BigInt:
+		return object.NegateInteger(right)
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
+}
+
+// evalAssignExpression updates the nearest existing binding of the name,
+// rather than creating a new one in the current scope like let does.
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	current, ok := env.Get(node.Name.Value)
+	if !ok {
+		return newError("assignment to undeclared variable: %s", node.Name.Value)
+	}
+
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	if node.Operator != "=" {
+		val = evalInfixExpression(strings.TrimSuffix(node.Operator, "="), current, val)
+		if isError(val) {
+			return val
+		}
+	}
+
+	env.Assign(node.Name.Value, val)
+	return val
+}
+
+// evalLogicalExpression evaluates && and ||, only evaluating the right
+// operand if the left one doesn't already decide the result.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+
+	return booleanReference(isTruthy(right))
 }
 
 func evalInfixExpression(op string, left, right object.Object) object.Object {
 	switch {
+	case object.PromotesToFloat(left, right):
+		return evalFloatInfixExpression(op, left, right)
+	case object.IsInteger(left) && object.IsInteger(right):
+		return evalIntegerInfixExpression(op, left, right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), op, right.Type())
-	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(op, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(op, left, right)
 	case op == "==":
 		return booleanReference(left == right)
 	case op == "!=":
@@ -222,19 +549,54 @@ func evalInfixExpression(op string, left, right object.Object) object.Object {
 	}
 }
 
+// evalIntegerInfixExpression handles Integers and BigInts, promoting to a
+// BigInt when the result overflows.
 func evalIntegerInfixExpression(op string, left, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
+	switch op {
+	case "+":
+		return object.AddIntegers(left, right)
+	case "-":
+		return object.SubIntegers(left, right)
+	case "*":
+		return object.MulIntegers(left, right)
+	case "/":
+		quotient, ok := object.DivIntegers(left, right)
+		if !ok {
+			return newError("division by zero")
+		}
+		return quotient
+	case "<":
+		return booleanReference(object.CompareIntegers(left, right) < 0)
+	case "<=":
+		return booleanReference(object.CompareIntegers(left, right) <= 0)
+	case ">":
+		return booleanReference(object.CompareIntegers(left, right) > 0)
+	case ">=":
+		return booleanReference(object.CompareIntegers(left, right) >= 0)
+	case "==":
+		return booleanReference(object.CompareIntegers(left, right) == 0)
+	case "!=":
+		return booleanReference(object.CompareIntegers(left, right) != 0)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
+	}
+}
+
+// evalFloatInfixExpression handles arithmetic where at least one operand is
+// a float. Integers are promoted to floats first.
+func evalFloatInfixExpression(op string, left, right object.Object) object.Object {
+	leftVal := object.ToFloat(left)
+	rightVal := object.ToFloat(right)
 
 	switch op {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return &object.Float{Value: leftVal + rightVal}
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return &object.Float{Value: leftVal - rightVal}
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return &object.Float{Value: leftVal * rightVal}
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		return &object.Float{Value: leftVal / rightVal}
 	case "<":
 		return booleanReference(leftVal < rightVal)
 	case "<=":
@@ -250,7 +612,22 @@ func evalIntegerInfixExpression(op string, left, right object.Object) object.Obj
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
 	}
+}
+
+func evalStringInfixExpression(op string, left, right object.Object) object.Object {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
 
+	switch op {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return booleanReference(leftVal == rightVal)
+	case "!=":
+		return booleanReference(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
+	}
 }
 
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
@@ -259,13 +636,19 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 		return cond
 	}
 
+	var result object.Object
 	if isTruthy(cond) {
-		return Eval(ie.Consequence, env)
+		result = Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
-	} else {
+		result = Eval(ie.Alternative, env)
+	}
+
+	// A branch that is empty or ends in a statement without a value, like
+	// let or a loop, gives null
+	if result == nil {
 		return NULL
 	}
+	return result
 }
 
 func isTruthy(obj object.Object) bool {
@@ -293,12 +676,31 @@ func isError(obj object.Object) bool {
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
-	val, ok := env.Get(node.Value)
-	if !ok {
-		return newError("identifier not found: " + node.Value)
+	if val, ok := env.Get(node.Value); ok {
+		return val
 	}
 
-	return val
+	if builtin, ok := builtins[node.Value]; ok {
+		return builtin
+	}
+
+	return newError("identifier not found: " + node.Value)
+}
+
+// evalCall evaluates the function and arguments of a call. If either fails it
+// returns the error as the third result.
+func evalCall(node *ast.CallExpression, env *object.Environment) (object.Object, []object.Object, object.Object) {
+	fn := Eval(node.Function, env)
+	if isError(fn) {
+		return nil, nil, fn
+	}
+
+	args := evalExpressions(node.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return nil, nil, args[0]
+	}
+
+	return fn, args, nil
 }
 
 func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
@@ -315,25 +717,122 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
-	function, ok := fn.(*object.Function)
+func evalIndexExpression(left, index object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.ARRAY_OBJ:
+		return newError("array index must be INTEGER, got %s", index.Type())
+	case left.Type() == object.HASH_OBJ:
+		return evalHashIndexExpression(left, index)
+	default:
+		return newError("index operator not supported: %s", left.Type())
+	}
+}
+
+// evalArrayIndexExpression returns NULL for out of range indices so scripts
+// can probe the end of an array without erroring.
+func evalArrayIndexExpression(array, index object.Object) object.Object {
+	elements := array.(*object.Array).Elements
+	idx := index.(*object.Integer).Value
+	max := int64(len(elements) - 1)
+
+	if idx < 0 || idx > max {
+		return NULL
+	}
+
+	return elements[idx]
+}
+
+func evalHashIndexExpression(hash, index object.Object) object.Object {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("not a function: %s", fn.Type())
+		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	env := surroundFunctionEnv(function, args)
-	evaled := Eval(function.Body, env)
-	return unwrapReturnValue(evaled)
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		return NULL
+	}
+
+	return pair.Value
 }
 
-func surroundFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	pairs := make(map[object.HashKey]object.HashPair)
+
+	for keyNode, valueNode := range node.Pairs {
+		key := Eval(keyNode, env)
+		if isError(key) {
+			return key
+		}
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+
+		value := Eval(valueNode, env)
+		if isError(value) {
+			return value
+		}
 
-	for i, param := range fn.Parameters {
-		env.Set(param.Value, args[i])
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
 	}
 
-	return env
+	return &object.Hash{Pairs: pairs}
+}
+
+// applyFunction calls fn with args. The call is made at pos by code running
+// in the caller environment.
+func applyFunction(fn object.Object, args []object.Object, caller *object.Environment, pos token.Position) object.Object {
+	switch fn := fn.(type) {
+	case *object.Function:
+		frame, err := pushFrame(fn, caller.Frame(), pos, maxCallDepth(caller))
+		if err != nil {
+			return err
+		}
+
+		// Tail calls come back unmade, so make them here in a loop rather
+		// than recursing. Each one replaces the frame of the call it
+		// returned from.
+		for {
+			if len(args) != len(fn.Parameters) {
+				return newError("wrong number of arguments: want=%d, got=%d",
+					len(fn.Parameters), len(args))
+			}
+
+			if err := allocate(caller, object.EnvironmentSize(len(args))); err != nil {
+				return err
+			}
+			env := object.NewCallEnvironment(fn.Env, caller, frame)
+			for i, param := range fn.Parameters {
+				env.Set(param.Value, args[i])
+			}
+
+			result := unwrapReturnValue(evalTail(fn.Body, env))
+
+			call, ok := result.(*tailCall)
+			if !ok {
+				return result
+			}
+			fn, args = call.fn, call.args
+			frame = &object.CallFrame{Function: fn.Name, Pos: call.pos, Caller: frame.Caller, Depth: frame.Depth}
+		}
+	case *object.Builtin:
+		result := fn.Fn(args...)
+		if result == nil {
+			return NULL
+		}
+		if err := allocate(caller, object.SizeOf(result)); err != nil {
+			return err
+		}
+		return result
+	default:
+		return newError("not a function: %s", fn.Type())
+	}
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -341,16 +840,47 @@ func unwrapReturnValue(obj object.Object) object.Object {
 		return returnValue.Value
 	}
 
+	// Bodies ending in a statement without a value, like let or a loop,
+	// return null
+	if obj == nil {
+		return NULL
+	}
+
 	return obj
 }
 
 func quote(node ast.Node, env *object.Environment) object.Object {
-	node = evalUnquote(node, env)
+	// Unquoting replaces nodes in place, so work on a copy to leave the
+	// quoted code intact for the next time it's evaluated
+	node, err := evalUnquote(ast.Copy(node), env)
+	if err != nil {
+		return err
+	}
 	return &object.Quote{Node: node}
 }
 
-func evalUnquote(quoted ast.Node, env *object.Environment) ast.Node {
-	return ast.Modify(quoted, func(node ast.Node) ast.Node {
+// evalUnquote replaces the unquote calls in quoted with the values of their
+// arguments. It stops at the first argument that fails to evaluate, or that
+// has no AST representation, and returns the error.
+func evalUnquote(quoted ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	var err *object.Error
+
+	node := ast.Modify(quoted, func(node ast.Node) ast.Node {
+		if err != nil {
+			return node
+		}
+
+		// Names to bind come after their unquote calls have been replaced
+		if ident, ok := node.(*ast.Identifier); ok && ident.Unquote != nil {
+			name, ok := ident.Unquote.(*ast.Identifier)
+			if !ok {
+				err = newError("cannot bind %s, it isn't an identifier", ident.Unquote)
+				err.Pos = ident.Pos()
+				return node
+			}
+			return name
+		}
+
 		if !isUnquoteCall(node) {
 			return node
 		}
@@ -365,8 +895,22 @@ func evalUnquote(quoted ast.Node, env *object.Environment) ast.Node {
 		}
 
 		unquoted := Eval(call.Arguments[0], env)
-		return convertObjectToASTNode(unquoted)
+		if isError(unquoted) {
+			err = unquoted.(*object.Error)
+			return node
+		}
+
+		converted := convertObjectToASTNode(unquoted)
+		if converted == nil {
+			err = newError("cannot unquote %s", unquoted.Type())
+			err.Pos = call.Pos()
+			return node
+		}
+
+		return converted
 	})
+
+	return node, err
 }
 
 func isUnquoteCall(node ast.Node) bool {
@@ -387,6 +931,18 @@ func convertObjectToASTNode(obj object.Object) ast.Node {
 		}
 		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
 
+	case *object.BigInt:
+		t := token.Token{Type: token.INT, Literal: obj.Value.String()}
+		return &ast.BigIntLiteral{Token: t, Value: obj.Value}
+
+	case *object.Float:
+		t := token.Token{Type: token.FLOAT, Literal: obj.Inspect()}
+		return &ast.FloatLiteral{Token: t, Value: obj.Value}
+
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}
+
 	case *object.Boolean:
 		var t token.Token
 		if obj.Value {
@@ -1,8 +1,13 @@
 package evaluator
 
 import (
+	"context"
+	"fmt"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/tzcl/monkey/ast"
 	"github.com/tzcl/monkey/lexer"
@@ -40,6 +45,66 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntegers(t *testing.T) {
+	factorial := "let f = fn(n) { if (n <= 1) { 1 } else { n * f(n - 1) } };"
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"99999999999999999999 + 1", "100000000000000000000"},
+		{"-9223372036854775808", "-9223372036854775808"},
+		{"-9223372036854775808 == -9223372036854775807 - 1", "true"},
+		{factorial + "f(25)", "15511210043330985984000000"},
+		{factorial + "f(25) / f(23)", "600"},
+		{factorial + "f(25) > f(24)", "true"},
+		{factorial + "f(25) == f(25)", "true"},
+		{factorial + "f(25) > 9223372036854775807", "true"},
+		{factorial + "-f(25)", "-15511210043330985984000000"},
+		{factorial + "f(25) * 1.0", "1.5511210043330986e+25"},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			evaled := testEval(tt.input)
+			if evaled.Inspect() != tt.expected {
+				t.Errorf("wrong result. want=%s, got=%s", tt.expected, evaled.Inspect())
+			}
+		})
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"2.5", 2.5},
+		{"-2.5", -2.5},
+		{"1e-3", 0.001},
+		{"1 + 2.5", 3.5},
+		{"2.5 + 1", 3.5},
+		{"7 / 2.0", 3.5},
+		{"0.5 * 4", 2},
+		{"1.5 - 2", -0.5},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			evaled := testEval(tt.input)
+			float, ok := evaled.(*object.Float)
+			if !ok {
+				t.Fatalf("object is not Float. got=%T (%+v)", evaled, evaled)
+			}
+			if float.Value != tt.expected {
+				t.Errorf("object has wrong value. got=%g, want=%g", float.Value, tt.expected)
+			}
+		})
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -64,6 +129,23 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 == 1.0", true},
+		{"1.5 < 2", true},
+		{"2 >= 2.5", false},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 && 0", true},
+		{"if (false) { 1 } || false", false},
+		// The right operand isn't evaluated when the left decides the result
+		{"false && undefined", false},
+		{"true || undefined", true},
 	}
 
 	for i, tt := range tests {
@@ -161,6 +243,30 @@ func TestErrorHandling(t *testing.T) {
 			"-true",
 			"unknown operator: -BOOLEAN",
 		},
+		{
+			"1.5 + true",
+			"type mismatch: FLOAT + BOOLEAN",
+		},
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			"a = 1",
+			"assignment to undeclared variable: a",
+		},
+		{
+			"for (x in 1) {}",
+			"cannot iterate over INTEGER",
+		},
+		{
+			"let f = fn(x) { x() }; f(1)",
+			"not a function: INTEGER",
+		},
+		{
+			`let a = 1; a += "x"`,
+			"type mismatch: INTEGER + STRING",
+		},
 		{
 			"true + false;",
 			"unknown operator: BOOLEAN + BOOLEAN",
@@ -185,6 +291,42 @@ if (10 > 1) {
 			"unknown operator: BOOLEAN + BOOLEAN",
 		},
 		{"foobar", "identifier not found: foobar"},
+		{
+			`"Hello" - "World"`,
+			"unknown operator: STRING - STRING",
+		},
+		{
+			`"Hello" + 1`,
+			"type mismatch: STRING + INTEGER",
+		},
+		{
+			`[1, 2][true]`,
+			"array index must be INTEGER, got BOOLEAN",
+		},
+		{
+			`5[0]`,
+			"index operator not supported: INTEGER",
+		},
+		{
+			`{"name": "Monkey"}[fn(x) { x }];`,
+			"unusable as hash key: FUNCTION",
+		},
+		{
+			`{[1]: 2}`,
+			"unusable as hash key: ARRAY",
+		},
+		{
+			"fn(x, y) { x }(1)",
+			"wrong number of arguments: want=2, got=1",
+		},
+		{
+			"quote()",
+			"wrong number of arguments to quote: want=1, got=0",
+		},
+		{
+			"quote(unquote(fn() { 1 }))",
+			"cannot unquote FUNCTION",
+		},
 	}
 
 	for _, tt := range tests {
@@ -202,6 +344,44 @@ if (10 > 1) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"5 + true;",
+			"ERROR: 1:1: type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			"let x = 1;\nlet y = -true;",
+			"ERROR: 2:9: unknown operator: -BOOLEAN",
+		},
+		{
+			"let f = fn() {\n  foobar\n};\nf();",
+			"ERROR: 2:3: identifier not found: foobar",
+		},
+		{
+			"\n  len(1)",
+			"ERROR: 2:3: argument to `len` not supported, got INTEGER",
+		},
+	}
+
+	for _, tt := range tests {
+		evaled := testEval(tt.input)
+
+		errObj, ok := evaled.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T (%+v)", evaled, evaled)
+			continue
+		}
+
+		if errObj.Inspect() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errObj.Inspect())
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -217,6 +397,229 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 5; a = 10; a;", 10},
+		{"let a = 5; a = 10;", 10},
+		{"let a = 1; let b = 2; a = b = 3; a + b;", 6},
+		{"let a = 5; a += 2; a;", 7},
+		{"let a = 5; a -= 2; a;", 3},
+		{"let a = 5; a *= 2; a;", 10},
+		{"let a = 5; a /= 2; a;", 2},
+		// Assignment updates the binding in the scope it was declared in
+		{"let a = 1; let f = fn() { a = 2; }; f(); a;", 2},
+		{"let a = 1; let f = fn(a) { a = 2; }; f(5); a;", 1},
+		{
+			`
+			let counter = fn() {
+				let n = 0;
+				fn() { n += 1; }
+			};
+			let c = counter();
+			c(); c();
+			c();
+			`,
+			3,
+		},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 10) { i += 1; }; i;", 10},
+		{"let i = 0; while (true) { i += 1; if (i == 5) { break; } }; i;", 5},
+		{
+			"let i = 0; let sum = 0; while (i < 10) { i += 1; if (i > 3) { continue; } sum += i; }; sum;",
+			6,
+		},
+		{"let sum = 0; for (x in [1, 2, 3]) { sum += x; }; sum;", 6},
+		{`let s = ""; for (c in "abc") { s = c + s; }; s;`, "cba"},
+		{`let s = ""; for (k in {"b": 1, "a": 2}) { s += k; }; s;`, "ab"},
+		{"let sum = 0; for (x in [1, 2, 3]) { if (x == 2) { continue; } sum += x; }; sum;", 4},
+		{
+			"let sum = 0; for (x in [1, 2]) { for (y in [10, 20]) { if (y == 20) { break; } sum += x * y; } }; sum;",
+			30,
+		},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x; } } }; f();", 2},
+		{"let f = fn() { while (false) {} }; f();", nil},
+		// The loop variable is scoped to the body
+		{"let x = 5; for (x in [1, 2]) {}; x;", 5},
+		// A loop runs without growing the Go stack
+		{"let i = 0; while (i < 100000) { i += 1; }; i;", 100000},
+	}
+
+	for _, tt := range tests {
+		evaled := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaled, int64(expected))
+		case string:
+			str, ok := evaled.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("wrong result for %q. want=%q, got=%s", tt.input, expected, evaled.Inspect())
+			}
+		default:
+			testNullObject(t, evaled)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	// Without tail calls these would overflow the Go stack
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{
+			"let count = fn(n) { if (n == 0) { 0 } else { count(n - 1) } }; count(300000);",
+			0,
+		},
+		{
+			"let count = fn(n) { if (n == 0) { return 0; } return count(n - 1); }; count(300000);",
+			0,
+		},
+		{
+			"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(300000, 0);",
+			45000150000,
+		},
+		{
+			`
+			let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+			let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+			even(300001);
+			`,
+			false,
+		},
+		// Tail calls to builtins and from the top level still work
+		{"let f = fn(x) { len(x) }; f([1, 2]);", 2},
+		{"let f = fn() { 3 }; return f();", 3},
+	}
+
+	for _, tt := range tests {
+		evaled := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaled, int64(expected))
+		case bool:
+			testBooleanObject(t, evaled, expected)
+		}
+	}
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	input := `let down = fn(n) {
+  1 + down(n - 1)
+};
+let start = fn() { down(3) + 0 };
+start();`
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+	evaled := EvalContext(context.Background(), program, object.NewEnvironment(), Limits{CallDepth: 100})
+	errObj, ok := evaled.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T (%+v)", evaled, evaled)
+	}
+
+	if errObj.Message != "maximum call depth of 100 exceeded" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+
+	expectedStack := []string{
+		"at down (line 2)",
+		"at down (line 2)",
+		"at down (line 2)",
+		"at down (line 2)",
+		"at down (line 2)",
+		"at down (line 2)",
+		"at down (line 2)",
+		"at down (line 2)",
+		"at down (line 2)",
+		"at down (line 2)",
+		"... 86 more calls",
+		"at down (line 2)",
+		"at down (line 2)",
+		"at down (line 2)",
+		"at start (line 4)",
+		"at <top level> (line 5)",
+	}
+	if !reflect.DeepEqual(errObj.Stack, expectedStack) {
+		t.Errorf("wrong stack.\nwant=%q\ngot=%q", expectedStack, errObj.Stack)
+	}
+
+	// Tail calls replace their caller's frame, so they don't count
+	program = parser.New(lexer.New("let count = fn(n) { if (n == 0) { 0 } else { count(n - 1) } }; count(1000);")).ParseProgram()
+	evaled = EvalContext(context.Background(), program, object.NewEnvironment(), Limits{CallDepth: 100})
+	testIntegerObject(t, evaled, 0)
+
+	// Without limits the default applies
+	evaled = testEval("let f = fn() { 1 + f() }; f();")
+	errObj, ok = evaled.(*object.Error)
+	if !ok || errObj.Message != fmt.Sprintf("maximum call depth of %d exceeded", DefaultCallDepth) {
+		t.Errorf("default call depth not applied. got=%s", evaled.Inspect())
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	tests := []struct {
+		ctx      context.Context
+		input    string
+		limits   Limits
+		expected interface{}
+	}{
+		{context.Background(), "let f = fn() { f() }; f();", Limits{Steps: 1000}, StepLimitMessage},
+		{context.Background(), "let i = 0; while (true) { i += 1 };", Limits{Steps: 1000}, StepLimitMessage},
+		{timeout, "while (true) {}", Limits{}, CancelledMessage},
+		{cancelled, "1 + 2", Limits{}, CancelledMessage},
+		{context.Background(), "let f = fn(x) { x * 2 }; f(5);", Limits{Steps: 1000}, 10},
+		{context.Background(), "let f = fn(x) { x * 2 }; f(5);", Limits{}, 10},
+		{context.Background(), `let s = "x"; while (true) { s += s };`, Limits{Memory: 1 << 20}, MemoryLimitMessage},
+		{context.Background(), "let a = []; while (true) { a = push(a, 1) };", Limits{Memory: 1 << 20}, MemoryLimitMessage},
+		{context.Background(), "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(100000);", Limits{Memory: 1 << 20}, MemoryLimitMessage},
+		{context.Background(), "for (x in [1, 2, 3]) { }", Limits{Memory: 100}, MemoryLimitMessage},
+		{context.Background(), "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(100);", Limits{Memory: 1 << 20}, 0},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		env := object.NewEnvironment()
+
+		evaled := EvalContext(tt.ctx, program, env, tt.limits)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaled, int64(expected))
+		case string:
+			errObj, ok := evaled.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T (%+v)", evaled, evaled)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+
+		if env.Budget() != nil {
+			t.Errorf("budget left on environment after evaluation")
+		}
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
 
@@ -270,6 +673,230 @@ addTwo(5);`
 	testIntegerObject(t, testEval(input), 7)
 }
 
+func TestStringLiteral(t *testing.T) {
+	input := `"Hello World!"`
+
+	evaled := testEval(input)
+	str, ok := evaled.(*object.String)
+	if !ok {
+		t.Fatalf("object is not String. got=%T (%+v)", evaled, evaled)
+	}
+
+	if str.Value != "Hello World!" {
+		t.Errorf("String has wrong value. got=%q", str.Value)
+	}
+}
+
+func TestStringConcatenation(t *testing.T) {
+	input := `"Hello" + " " + "World!"`
+
+	evaled := testEval(input)
+	str, ok := evaled.(*object.String)
+	if !ok {
+		t.Fatalf("object is not String. got=%T (%+v)", evaled, evaled)
+	}
+
+	if str.Value != "Hello World!" {
+		t.Errorf("String has wrong value. got=%q", str.Value)
+	}
+}
+
+func TestStringComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "a"`, false},
+		{`"a" != "b"`, true},
+		{`"a" + "b" == "ab"`, true},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	evaled := testEval(input)
+	result, ok := evaled.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaled, evaled)
+	}
+
+	if len(result.Elements) != 3 {
+		t.Fatalf("array has wrong num of elements. got=%d", len(result.Elements))
+	}
+
+	testIntegerObject(t, result.Elements[0], 1)
+	testIntegerObject(t, result.Elements[1], 4)
+	testIntegerObject(t, result.Elements[2], 6)
+}
+
+func TestArrayIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][2]", 3},
+		{"let i = 0; [1][i];", 1},
+		{"[1, 2, 3][1 + 1];", 3},
+		{"let myArray = [1, 2, 3]; myArray[2];", 3},
+		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
+		{"[1, 2, 3][3]", nil},
+		{"[1, 2, 3][-1]", nil},
+	}
+
+	for _, tt := range tests {
+		evaled := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaled, int64(integer))
+		} else {
+			testNullObject(t, evaled)
+		}
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `let two = "two";
+	{
+		"one": 10 - 9,
+		two: 1 + 1,
+		"thr" + "ee": 6 / 2,
+		4: 4,
+		true: 5,
+		false: 6
+	}`
+
+	evaled := testEval(input)
+	result, ok := evaled.(*object.Hash)
+	if !ok {
+		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaled, evaled)
+	}
+
+	expected := map[object.HashKey]int64{
+		(&object.String{Value: "one"}).HashKey():   1,
+		(&object.String{Value: "two"}).HashKey():   2,
+		(&object.String{Value: "three"}).HashKey(): 3,
+		(&object.Integer{Value: 4}).HashKey():      4,
+		TRUE.HashKey():                             5,
+		FALSE.HashKey():                            6,
+	}
+
+	if len(result.Pairs) != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	}
+
+	for expectedKey, expectedValue := range expected {
+		pair, ok := result.Pairs[expectedKey]
+		if !ok {
+			t.Errorf("no pair for given key in Pairs")
+		}
+
+		testIntegerObject(t, pair.Value, expectedValue)
+	}
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+	}
+
+	for _, tt := range tests {
+		evaled := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaled, int64(integer))
+		} else {
+			testNullObject(t, evaled)
+		}
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len("🐈🐕")`, 2},
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`len({"a": 1})`, 1},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, nil},
+		{`first(1)`, "argument to `first` must be ARRAY, got INTEGER"},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, nil},
+		{`last(1)`, "argument to `last` must be ARRAY, got INTEGER"},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`rest([])`, nil},
+		{`rest()`, "wrong number of arguments. got=0, want=1"},
+		{`push([], 1)`, []int{1}},
+		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`push([1])`, "wrong number of arguments. got=1, want=2"},
+		{`let a = [1]; push(a, 2); a`, []int{1}},
+		{`puts("hello", "world!")`, nil},
+		{`let len = fn(x) { 42 }; len("abc")`, 42},
+	}
+
+	for _, tt := range tests {
+		evaled := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaled, int64(expected))
+		case nil:
+			testNullObject(t, evaled)
+		case string:
+			errObj, ok := evaled.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaled, evaled)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		case []int:
+			array, ok := evaled.(*object.Array)
+			if !ok {
+				t.Errorf("obj not Array. got=%T (%+v)", evaled, evaled)
+				continue
+			}
+
+			if len(array.Elements) != len(expected) {
+				t.Errorf("wrong num of elements. want=%d, got=%d",
+					len(expected), len(array.Elements))
+				continue
+			}
+
+			for i, expectedElem := range expected {
+				testIntegerObject(t, array.Elements[i], int64(expectedElem))
+			}
+		}
+	}
+}
+
 func TestQuote(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -323,6 +950,10 @@ func TestUnquote(t *testing.T) {
 			`quote(unquote(4 + 4))`,
 			`8`,
 		},
+		{
+			`quote(unquote(9223372036854775807 + 1))`,
+			`9223372036854775808`,
+		},
 		{
 			`quote(8 + unquote(4 + 4))`,
 			`(8 + 8)`,
@@ -467,6 +1098,66 @@ func TestExpandMacros(t *testing.T) {
             `,
 			`if (!(10 > 5)) { 1; } else { 2; }`,
 		},
+		{
+			`
+            let unless = macro(condition, consequence, alternative) {
+                quote(if (!(unquote(condition))) {
+                    unquote(consequence);
+                } else {
+                    unquote(alternative);
+                });
+            };
+            let twice = macro(x) { quote(double(unquote(x), unquote(x))); };
+
+            puts(unless(10 > 5, 1, 2));
+            twice(unless(true, 3, 4));
+            `,
+			`puts(if (!(10 > 5)) { 1; } else { 2; });
+            double(if (!(true)) { 3; } else { 4; }, if (!(true)) { 3; } else { 4; });`,
+		},
+		{
+			`
+            let inc = macro(x) { quote(unquote(x) + 1); };
+            let incTwice = macro(x) { quote(inc(inc(unquote(x)))); };
+
+            incTwice(1);
+            `,
+			`((1 + 1) + 1)`,
+		},
+		{
+			`
+            let inc = macro(x) {
+                let build = fn(x) { quote(unquote(x) + 1); };
+                return build(x);
+            };
+
+            inc(1);
+            `,
+			`(1 + 1)`,
+		},
+		{
+			`
+            let triple = fn(x) {
+                let thrice = macro(x) { quote(unquote(x) * 3); };
+                thrice(x)
+            };
+            `,
+			`let triple = fn(x) { (x * 3) };`,
+		},
+		{
+			`
+            let m = macro() { quote(1); };
+
+            if (true) {
+                let m = macro() { quote(2); };
+                let n = macro() { quote(3); };
+                m() + n();
+            };
+            m();
+            n();
+            `,
+			`if (true) { (2 + 3) }; 1; n();`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -475,7 +1166,10 @@ func TestExpandMacros(t *testing.T) {
 
 		env := object.NewEnvironment()
 		DefineMacros(program, env)
-		expanded := ExpandMacros(program, env)
+		expanded, errs := ExpandMacros(program, env)
+		if len(errs) != 0 {
+			t.Fatalf("ExpandMacros returned errors: %v", errs)
+		}
 
 		if expanded.String() != expected.String() {
 			t.Errorf("not equal. want=%q, got=%q",
@@ -523,6 +1217,281 @@ func testNullObject(t *testing.T, obj object.Object) bool {
 	return true
 }
 
+func TestExpandMacroErrors(t *testing.T) {
+	input := `
+let twice = macro(x) { quote(unquote(x) + unquote(x)) };
+let number = macro() { 1 };
+let broken = macro() { quote(unquote(missing)) };
+let array = macro() { quote(unquote([1])) };
+let returning = macro(x) { return quote(unquote(x) * 2); };
+
+twice(1, 2);
+number();
+broken();
+array();
+returning(3);
+`
+
+	program := testParseProgram(input)
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+
+	expanded, errs := ExpandMacros(program, env)
+
+	expected := []string{
+		"8:1: cannot expand macro twice: wrong number of arguments: want=1, got=2",
+		"9:1: cannot expand macro number: macro returned INTEGER, not a quoted AST node",
+		"10:1: cannot expand macro broken: 4:38: identifier not found: missing",
+		"11:1: cannot expand macro array: 5:29: cannot unquote ARRAY",
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("wrong number of errors. want=%d, got=%d (%v)", len(expected), len(errs), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("errors[%d] wrong. want=%q, got=%q", i, expected[i], err.Error())
+		}
+	}
+
+	if errs[0].Macro != "twice" || errs[0].Pos.Line != 8 {
+		t.Errorf("wrong macro or position. got=%s at %s", errs[0].Macro, errs[0].Pos)
+	}
+
+	// Calls that expanded are still replaced, the rest are left alone
+	statements := expanded.(*ast.Program).Statements
+	if got := statements[4].String(); got != "(3 * 2)" {
+		t.Errorf("returning macro not expanded. got=%q", got)
+	}
+	if got := statements[0].String(); got != "twice(1, 2)" {
+		t.Errorf("failed call was changed. got=%q", got)
+	}
+}
+
+func TestMacroExpansionLimits(t *testing.T) {
+	defer func(depth int) { MaxMacroDepth = depth }(MaxMacroDepth)
+	MaxMacroDepth = 10
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let loop = macro(x) { quote(loop(unquote(x))) };
+loop(1);`,
+			"1:29: cannot expand macro loop: expansion cycle: loop(1) -> loop(1)",
+		},
+		{
+			`let a = macro() { quote(b()) };
+let b = macro() { quote(a()) };
+a();`,
+			"2:25: cannot expand macro a: expansion cycle: a() -> b() -> a()",
+		},
+		{
+			`let grow = macro(x) { quote(grow(unquote(x) + 1)) };
+grow(1);`,
+			"1:29: cannot expand macro grow: maximum macro expansion depth of 10 exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+
+		_, errs := ExpandMacros(program, env)
+		if len(errs) != 1 {
+			t.Fatalf("wrong number of errors. want=1, got=%d (%v)", len(errs), errs)
+		}
+		if errs[0].Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, errs[0].Error())
+		}
+	}
+}
+
+func TestMacrosExpandedTwice(t *testing.T) {
+	input := `
+let plusOne = macro(x) { quote(unquote(x) + 1) };
+[plusOne(1), plusOne(2)];
+`
+
+	program := testParseProgram(input)
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+	expanded, _ := ExpandMacros(program, env)
+
+	if got := expanded.String(); got != "[(1 + 1), (2 + 1)]" {
+		t.Errorf("wrong expansion. got=%q", got)
+	}
+}
+
+func TestHygienicMacros(t *testing.T) {
+	tests := []struct {
+		input      string
+		unhygienic int64
+		hygienic   int64
+	}{
+		{
+			// The macro's tmp would capture the caller's
+			`
+			let plusTmp = macro(x) { quote(if (true) { let tmp = 10; tmp + unquote(x) }) };
+			let tmp = 1;
+			plusTmp(tmp);
+			`,
+			20,
+			11,
+		},
+		{
+			// The caller's assignment would change the macro's helper
+			`
+			let withHelper = macro(body) { quote(if (true) { let helper = 5; unquote(body); helper }) };
+			let helper = 0;
+			withHelper(helper = 100);
+			`,
+			100,
+			5,
+		},
+		{
+			// Free names in the template still refer to globals
+			`
+			let double = fn(x) { x * 2 };
+			let twice = macro(x) { quote(if (true) { let n = unquote(x); double(n) }) };
+			let n = 3;
+			twice(n + 1);
+			`,
+			8,
+			8,
+		},
+		{
+			// Only n inside f is bound by the template, the other is global
+			`
+			let n = 7;
+			let apply = macro(x) { quote(if (true) { let f = fn(n) { n * 2 }; f(unquote(x)) + n }) };
+			apply(1);
+			`,
+			9,
+			9,
+		},
+		{
+			// Functions see lets that come after them, the rest only earlier ones
+			`
+			let x = 1;
+			let m = macro() { quote(if (true) { let y = x; let f = fn() { x }; let x = 10; y + f() }) };
+			m();
+			`,
+			11,
+			11,
+		},
+		{
+			// Recursive functions refer to themselves
+			`
+			let sum = macro(n) { quote(if (true) { let go = fn(i) { if (i == 0) { 0 } else { i + go(i - 1) } }; go(unquote(n)) }) };
+			let go = 0;
+			sum(3);
+			`,
+			6,
+			6,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, hygienic := range []bool{false, true} {
+			program := testParseProgram(tt.input)
+			macroEnv := object.NewEnvironment()
+			DefineMacros(program, macroEnv)
+
+			expand, expected := ExpandMacros, tt.unhygienic
+			if hygienic {
+				expand, expected = ExpandMacrosHygienic, tt.hygienic
+			}
+
+			expanded, errs := expand(program, macroEnv)
+			if len(errs) != 0 {
+				t.Fatalf("expansion failed: %v", errs)
+			}
+
+			evaled := Eval(expanded, object.NewEnvironment())
+			if !testIntegerObject(t, evaled, expected) {
+				t.Errorf("hygienic=%t: wrong result for %s", hygienic, expanded.String())
+			}
+		}
+	}
+}
+
+func TestGensym(t *testing.T) {
+	first := testEval(`gensym("tmp")`)
+	second := testEval(`gensym("tmp")`)
+
+	for _, obj := range []object.Object{first, second} {
+		quote, ok := obj.(*object.Quote)
+		if !ok {
+			t.Fatalf("gensym did not return Quote. got=%T (%+v)", obj, obj)
+		}
+		if ident, ok := quote.Node.(*ast.Identifier); !ok || !strings.HasPrefix(ident.Value, "tmp#") {
+			t.Errorf("gensym did not return a tmp identifier. got=%s", quote.Node)
+		}
+	}
+
+	if first.Inspect() == second.Inspect() {
+		t.Errorf("gensym returned the same name twice: %s", first.Inspect())
+	}
+
+	// Generated names can be bound by let, fn and for, and assigned to
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{
+			`let double = macro(v) { let t = gensym("t"); quote(if (true) { let unquote(t) = unquote(v); unquote(t) * 2 }) };
+			let t = 5;
+			double(t + 1);`,
+			12,
+		},
+		{
+			`let adder = macro(n) { let a = gensym("a"); quote(fn(unquote(a)) { unquote(a) + unquote(n) }) };
+			let a = 10;
+			adder(a)(1);`,
+			11,
+		},
+		{
+			`let sum = macro(xs) {
+				let x = gensym("x");
+				let s = gensym("s");
+				quote(if (true) { let unquote(s) = 0; for (unquote(x) in unquote(xs)) { unquote(s) += unquote(x) }; unquote(s) })
+			};
+			let s = 100;
+			sum([1, s]);`,
+			101,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, expand := range []func(*ast.Program, *object.Environment) (ast.Node, []*MacroError){
+			ExpandMacros, ExpandMacrosHygienic,
+		} {
+			program := testParseProgram(tt.input)
+			env := object.NewEnvironment()
+			DefineMacros(program, env)
+			expanded, errs := expand(program, env)
+			if len(errs) != 0 {
+				t.Fatalf("expansion failed: %v", errs)
+			}
+
+			testIntegerObject(t, Eval(expanded, object.NewEnvironment()), tt.expected)
+		}
+	}
+
+	evaled := testEval(`quote(fn(unquote(1)) { 1 })`)
+	if errObj, ok := evaled.(*object.Error); !ok || errObj.Message != "cannot bind 1, it isn't an identifier" {
+		t.Errorf("wrong error. got=%s", evaled.Inspect())
+	}
+
+	evaled = testEval(`gensym(1)`)
+	if errObj, ok := evaled.(*object.Error); !ok || errObj.Message != "argument to `gensym` must be STRING, got INTEGER" {
+		t.Errorf("wrong error. got=%s", evaled.Inspect())
+	}
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -0,0 +1,150 @@
+package evaluator
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/tzcl/monkey/ast"
+	"github.com/tzcl/monkey/object"
+	"github.com/tzcl/monkey/token"
+)
+
+// Generated names contain a '#', which the lexer never puts in an identifier,
+// so they can't clash with names written in a program.
+
+var gensymCounter atomic.Int64
+
+// newSymbol returns an identifier no other call has returned, based on name.
+func newSymbol(name string) *ast.Identifier {
+	symbol := fmt.Sprintf("%s#%d", name, gensymCounter.Add(1))
+	return &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: symbol}, Value: symbol}
+}
+
+// gensym returns a quoted identifier that is different from every other
+// identifier, for macros to bind without fear of capturing names from their
+// arguments. An optional string argument is used as the prefix of the name.
+func gensym(args ...object.Object) object.Object {
+	prefix := "g"
+
+	switch len(args) {
+	case 0:
+	case 1:
+		str, ok := args[0].(*object.String)
+		if !ok {
+			return newError("argument to `gensym` must be STRING, got %s", args[0].Type())
+		}
+		prefix = str.Value
+	default:
+		return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+	}
+
+	return &object.Quote{Node: newSymbol(prefix)}
+}
+
+// renameBindings gives fresh names to the identifiers bound by let, fn and
+// for in a macro expansion, and to the uses of those bindings. The macro's
+// arguments, spliced into the expansion with unquote, are left alone, so they
+// keep referring to the bindings at the call site.
+//
+// Uses are matched to bindings the way the evaluator looks names up. Code sees
+// the parameters or loop variable of the function or loop it's in, and the
+// lets that ran before it. Function bodies run later, after every let around
+// them, so they see all of them.
+func renameBindings(expansion ast.Node, args []ast.Expression) {
+	r := &renamer{spliced: map[ast.Node]bool{}}
+	for _, arg := range args {
+		r.spliced[arg] = true
+	}
+
+	r.rename(expansion, newRenameScope(nil))
+
+	for len(r.functions) > 0 {
+		fn := r.functions[0]
+		r.functions = r.functions[1:]
+
+		scope := newRenameScope(fn.scope)
+		for _, param := range fn.node.Parameters {
+			scope.bind(param)
+		}
+		r.rename(fn.node.Body, scope)
+	}
+}
+
+type renamer struct {
+	spliced map[ast.Node]bool
+
+	// Functions whose bodies are left to rename until the scopes around them
+	// are complete
+	functions []renameFunction
+}
+
+type renameFunction struct {
+	node  *ast.FunctionLiteral
+	scope *renameScope
+}
+
+// rename renames the bindings in node and the uses of bindings in scope.
+func (r *renamer) rename(node ast.Node, scope *renameScope) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		if node == nil || r.spliced[node] {
+			return false
+		}
+
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			r.rename(node.Value, scope)
+			scope.bind(node.Name)
+			return false
+		case *ast.ForStatement:
+			r.rename(node.Iterable, scope)
+			body := newRenameScope(scope)
+			body.bind(node.Variable)
+			r.rename(node.Body, body)
+			return false
+		case *ast.FunctionLiteral:
+			r.functions = append(r.functions, renameFunction{node: node, scope: scope})
+			return false
+		case *ast.Identifier:
+			if name, ok := scope.lookup(node.Value); ok {
+				setName(node, name)
+			}
+		}
+		return true
+	})
+}
+
+// renameScope is a function body, a loop body or the whole expansion, which
+// are the places the evaluator gives their own environment.
+type renameScope struct {
+	outer *renameScope
+	names map[string]string // what the names bound here are renamed to
+}
+
+func newRenameScope(outer *renameScope) *renameScope {
+	return &renameScope{outer: outer, names: map[string]string{}}
+}
+
+// bind renames ident, which is bound in s. Binding the same name again in s
+// assigns to the same variable, so it gets the same new name.
+func (s *renameScope) bind(ident *ast.Identifier) {
+	name, ok := s.names[ident.Value]
+	if !ok {
+		name = newSymbol(ident.Value).Value
+		s.names[ident.Value] = name
+	}
+	setName(ident, name)
+}
+
+func (s *renameScope) lookup(name string) (string, bool) {
+	for ; s != nil; s = s.outer {
+		if renamed, ok := s.names[name]; ok {
+			return renamed, true
+		}
+	}
+	return "", false
+}
+
+func setName(ident *ast.Identifier, name string) {
+	ident.Value = name
+	ident.Token.Literal = name
+}
@@ -0,0 +1,71 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/tzcl/monkey/object"
+	"github.com/tzcl/monkey/token"
+)
+
+// DefaultCallDepth is how deeply Monkey function calls may nest before
+// evaluation stops with an error, unless Limits says otherwise. Every call
+// that isn't a tail call costs Go stack, so this has to stay well below the
+// point where the Go runtime would abort the whole process.
+const DefaultCallDepth = 10000
+
+// Stack traces longer than this keep only their innermost and outermost calls.
+const (
+	stackTraceHead = 10
+	stackTraceTail = 5
+)
+
+// pushFrame returns the frame for a call to fn made at pos from caller, or an
+// error if the call would be more than maxDepth deep.
+func pushFrame(fn *object.Function, caller *object.CallFrame, pos token.Position, maxDepth int) (*object.CallFrame, *object.Error) {
+	depth := 1
+	if caller != nil {
+		depth = caller.Depth + 1
+	}
+
+	if depth > maxDepth {
+		err := newError("maximum call depth of %d exceeded", maxDepth)
+		err.Stack = stackTrace(caller, pos)
+		return nil, err
+	}
+
+	return &object.CallFrame{Function: fn.Name, Pos: pos, Caller: caller, Depth: depth}, nil
+}
+
+// maxCallDepth returns how deeply calls may nest in the evaluation running in
+// env.
+func maxCallDepth(env *object.Environment) int {
+	if budget := env.Budget(); budget != nil && budget.CallDepth > 0 {
+		return budget.CallDepth
+	}
+	return DefaultCallDepth
+}
+
+// stackTrace describes the call stack from frame outwards, where pos is the
+// position reached in the innermost frame.
+func stackTrace(frame *object.CallFrame, pos token.Position) []string {
+	var lines []string
+
+	for ; frame != nil; frame = frame.Caller {
+		name := frame.Function
+		if name == "" {
+			name = "<anonymous>"
+		}
+		lines = append(lines, fmt.Sprintf("at %s (line %d)", name, pos.Line))
+		pos = frame.Pos
+	}
+	lines = append(lines, fmt.Sprintf("at <top level> (line %d)", pos.Line))
+
+	if len(lines) > stackTraceHead+stackTraceTail+1 {
+		skipped := len(lines) - stackTraceHead - stackTraceTail
+		trimmed := append([]string{}, lines[:stackTraceHead]...)
+		trimmed = append(trimmed, fmt.Sprintf("... %d more calls", skipped))
+		lines = append(trimmed, lines[len(lines)-stackTraceTail:]...)
+	}
+
+	return lines
+}
@@ -0,0 +1,111 @@
+package evaluator
+
+import (
+	"github.com/tzcl/monkey/ast"
+	"github.com/tzcl/monkey/object"
+	"github.com/tzcl/monkey/token"
+)
+
+// Calls in tail position (the value of a return statement, or the last
+// expression of a function body) aren't made straight away. Instead they
+// evaluate to a tailCall, and applyFunction makes the call once the calling
+// function's body has returned. This trampolining keeps tail-recursive
+// functions running in constant Go stack.
+
+// tailCall is a call to a Monkey function that still has to be made. It never
+// escapes the evaluator.
+type tailCall struct {
+	fn   *object.Function
+	args []object.Object
+	pos  token.Position // where the call was made
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// evalTail is Eval for a node in tail position.
+func evalTail(node ast.Node, env *object.Environment) object.Object {
+	var result object.Object
+
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		result = evalTailBlockStatement(node, env)
+	case *ast.ExpressionStatement:
+		result = evalTail(node.Expression, env)
+	case *ast.IfExpression:
+		result = evalTailIfExpression(node, env)
+	case *ast.CallExpression:
+		result = evalTailCallExpression(node, env)
+	default:
+		return Eval(node, env)
+	}
+
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+
+	return result
+}
+
+func evalTailBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+	if len(block.Statements) == 0 {
+		return nil
+	}
+
+	last := len(block.Statements) - 1
+
+	result := evalBlockStatements(block.Statements[:last], env)
+	if interruptsBlock(result) {
+		return result
+	}
+
+	return evalTail(block.Statements[last], env)
+}
+
+func evalTailIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	cond := Eval(ie.Condition, env)
+	if isError(cond) {
+		return cond
+	}
+
+	var result object.Object
+	if isTruthy(cond) {
+		result = evalTail(ie.Consequence, env)
+	} else if ie.Alternative != nil {
+		result = evalTail(ie.Alternative, env)
+	}
+
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+func evalTailCallExpression(node *ast.CallExpression, env *object.Environment) object.Object {
+	if node.Function.TokenLiteral() == "quote" {
+		return Eval(node, env)
+	}
+
+	fn, args, err := evalCall(node, env)
+	if err != nil {
+		return err
+	}
+
+	// Only calls to Monkey functions recurse into Eval, builtins can be
+	// called straight away
+	function, ok := fn.(*object.Function)
+	if !ok {
+		return applyFunction(fn, args, env, node.Pos())
+	}
+
+	return &tailCall{fn: function, args: args, pos: node.Pos()}
+}
+
+// resolveTailCall makes obj's call from the caller environment if it is a
+// tailCall.
+func resolveTailCall(obj object.Object, caller *object.Environment) object.Object {
+	if call, ok := obj.(*tailCall); ok {
+		return applyFunction(call.fn, call.args, caller, call.pos)
+	}
+	return obj
+}
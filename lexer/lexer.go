@@ -2,10 +2,20 @@
 package lexer
 
 import (
-	"github.com/dmolesUC3/emoji"
-	"github.com/tzcl/monkey/token"
+	"fmt"
+	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/dmolesUC3/emoji"
+	"github.com/tzcl/monkey/token"
+)
+
+// The literals of ILLEGAL tokens describe what's wrong with them.
+const (
+	UnterminatedString  = "unterminated string"
+	UnterminatedComment = "unterminated comment"
 )
 
 type Lexer struct {
@@ -13,10 +23,19 @@ type Lexer struct {
 	position     int  // points to current char
 	readPosition int  // points after current char (allows us to peek)
 	r            rune // current rune being processed
+
+	filename string
+	line     int // line of the current char
+	column   int // column of the current char
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile returns a lexer whose token positions are tagged with filename.
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readRune()
 	return l
 }
@@ -24,7 +43,9 @@ func New(input string) *Lexer {
 func (l *Lexer) NextToken() token.Token {
 	var t token.Token
 
-	l.skipWhitespace()
+	comments := l.skipTrivia()
+
+	pos := l.currPosition()
 
 	switch l.r {
 	case '=':
@@ -34,9 +55,17 @@ func (l *Lexer) NextToken() token.Token {
 			t = l.makeToken(token.ASSIGN)
 		}
 	case '+':
-		t = l.makeToken(token.PLUS)
+		if l.peekRune() == '=' {
+			t = l.makeTwoRuneToken(token.PLUS_ASSIGN)
+		} else {
+			t = l.makeToken(token.PLUS)
+		}
 	case '-':
-		t = l.makeToken(token.MINUS)
+		if l.peekRune() == '=' {
+			t = l.makeTwoRuneToken(token.MINUS_ASSIGN)
+		} else {
+			t = l.makeToken(token.MINUS)
+		}
 	case '!':
 		if l.peekRune() == '=' {
 			t = l.makeTwoRuneToken(token.NOT_EQ)
@@ -44,17 +73,54 @@ func (l *Lexer) NextToken() token.Token {
 			t = l.makeToken(token.BANG)
 		}
 	case '*':
-		t = l.makeToken(token.ASTERISK)
+		if l.peekRune() == '=' {
+			t = l.makeTwoRuneToken(token.ASTERISK_ASSIGN)
+		} else {
+			t = l.makeToken(token.ASTERISK)
+		}
 	case '/':
-		t = l.makeToken(token.SLASH)
+		if l.peekRune() == '*' {
+			// skipTrivia leaves unterminated block comments behind
+			t.Type = token.ILLEGAL
+			t.Literal = UnterminatedComment
+			for l.peekRune() != 0 {
+				l.readRune()
+			}
+		} else if l.peekRune() == '=' {
+			t = l.makeTwoRuneToken(token.SLASH_ASSIGN)
+		} else {
+			t = l.makeToken(token.SLASH)
+		}
 	case '<':
-		t = l.makeToken(token.LT)
+		if l.peekRune() == '=' {
+			t = l.makeTwoRuneToken(token.LT_EQ)
+		} else {
+			t = l.makeToken(token.LT)
+		}
 	case '>':
-		t = l.makeToken(token.GT)
+		if l.peekRune() == '=' {
+			t = l.makeTwoRuneToken(token.GT_EQ)
+		} else {
+			t = l.makeToken(token.GT)
+		}
+	case '&':
+		if l.peekRune() == '&' {
+			t = l.makeTwoRuneToken(token.AND)
+		} else {
+			t = l.makeIllegalToken()
+		}
+	case '|':
+		if l.peekRune() == '|' {
+			t = l.makeTwoRuneToken(token.OR)
+		} else {
+			t = l.makeIllegalToken()
+		}
 	case ',':
 		t = l.makeToken(token.COMMA)
 	case ';':
 		t = l.makeToken(token.SEMICOLON)
+	case ':':
+		t = l.makeToken(token.COLON)
 	case '(':
 		t = l.makeToken(token.LPAREN)
 	case ')':
@@ -63,6 +129,18 @@ func (l *Lexer) NextToken() token.Token {
 		t = l.makeToken(token.LBRACE)
 	case '}':
 		t = l.makeToken(token.RBRACE)
+	case '[':
+		t = l.makeToken(token.LBRACKET)
+	case ']':
+		t = l.makeToken(token.RBRACKET)
+	case '"':
+		if str, ok := l.readString(); ok {
+			t.Type = token.STRING
+			t.Literal = str
+		} else {
+			t.Type = token.ILLEGAL
+			t.Literal = str
+		}
 	case 0:
 		t.Literal = ""
 		t.Type = token.EOF
@@ -70,27 +148,103 @@ func (l *Lexer) NextToken() token.Token {
 		if unicode.IsLetter(l.r) || isEmoji(l.r) {
 			t.Literal = l.readIdentifier()
 			t.Type = token.IdentType(t.Literal)
+			t.Pos = pos
+			t.Comments = comments
 			return t
-		} else if unicode.IsDigit(l.r) {
-			t.Literal = l.readNumber()
-			t.Type = token.INT
+		} else if isDigit(l.r) {
+			t.Literal, t.Type = l.readNumber()
+			t.Pos = pos
+			t.Comments = comments
 			return t
 		} else {
-			t = l.makeToken(token.ILLEGAL)
+			t = l.makeIllegalToken()
 		}
 	}
 
 	l.readRune()
+	t.Pos = pos
+	t.Comments = comments
 	return t
 }
 
+func (l *Lexer) currPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
 func (l *Lexer) skipWhitespace() {
 	for unicode.IsSpace(l.r) {
 		l.readRune()
 	}
 }
 
+// skipTrivia skips whitespace, // line comments and /* */ block comments,
+// returning the comments it passed over. Block comments nest. An unterminated
+// block comment is left in place for NextToken to report.
+func (l *Lexer) skipTrivia() []string {
+	var comments []string
+
+	for {
+		l.skipWhitespace()
+
+		if l.r != '/' {
+			return comments
+		}
+
+		start := l.position
+		switch l.peekRune() {
+		case '/':
+			for l.r != '\n' && l.r != 0 {
+				l.readRune()
+			}
+		case '*':
+			end := blockCommentEnd(l.input, start)
+			if end < 0 {
+				return comments
+			}
+			for l.position < end {
+				l.readRune()
+			}
+		default:
+			return comments
+		}
+
+		comments = append(comments, l.input[start:l.position])
+	}
+}
+
+// blockCommentEnd returns the offset just past the block comment starting at
+// input[start:], or -1 if it is never closed.
+func blockCommentEnd(input string, start int) int {
+	depth := 0
+	for i := start; i+1 < len(input); i++ {
+		switch input[i : i+2] {
+		case "/*":
+			depth++
+			i++
+		case "*/":
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
 func (l *Lexer) readRune() {
+	if l.r == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+
 	size := 1
 	if l.readPosition >= len(l.input) {
 		l.r = 0 // represents "NUL"
@@ -114,6 +268,10 @@ func (l *Lexer) makeToken(tokenType token.TokenType) token.Token {
 	return token.Token{Type: tokenType, Literal: string(l.r)}
 }
 
+func (l *Lexer) makeIllegalToken() token.Token {
+	return token.Token{Type: token.ILLEGAL, Literal: fmt.Sprintf("unexpected character %q", l.r)}
+}
+
 func (l *Lexer) makeTwoRuneToken(tokenType token.TokenType) token.Token {
 	r := l.r
 	l.readRune()
@@ -130,12 +288,131 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer or a float. Floats need digits on both sides of
+// the point, and may have an exponent: 1.5, 2e10, 1.5e-9.
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
-	for unicode.IsDigit(l.r) {
+	tokenType := token.TokenType(token.INT)
+
+	l.readDigits()
+
+	if l.r == '.' && isDigit(l.peekRune()) {
+		tokenType = token.FLOAT
 		l.readRune()
+		l.readDigits()
+	}
+
+	if l.r == 'e' || l.r == 'E' {
+		// Only treat it as an exponent if digits follow, so we don't eat
+		// the start of an identifier
+		rest := l.input[l.readPosition:]
+		if len(rest) > 0 && (rest[0] == '+' || rest[0] == '-') {
+			rest = rest[1:]
+		}
+		if len(rest) > 0 && isDigit(rune(rest[0])) {
+			tokenType = token.FLOAT
+			l.readRune()
+			if l.r == '+' || l.r == '-' {
+				l.readRune()
+			}
+			l.readDigits()
+		}
+	}
+
+	return l.input[position:l.position], tokenType
+}
+
+func (l *Lexer) readDigits() {
+	for isDigit(l.r) {
+		l.readRune()
+	}
+}
+
+// isDigit only accepts ASCII digits, which is all strconv understands.
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+// readString reads a double-quoted string literal, resolving escape sequences
+// as it goes. It leaves the lexer on the closing quote. If the literal is
+// unterminated or contains a bad escape, it returns what's wrong and false.
+// A bad escape doesn't end the literal, so the rest of it isn't read as code.
+func (l *Lexer) readString() (string, bool) {
+	var out strings.Builder
+	problem := ""
+
+	for {
+		l.readRune()
+
+		switch l.r {
+		case '"':
+			if problem != "" {
+				return problem, false
+			}
+			return out.String(), true
+		case 0:
+			return UnterminatedString, false
+		case '\\':
+			start := l.position
+			l.readRune()
+			escape := l.r
+
+			// Escapes cut short by the end of the input are reported as an
+			// unterminated string
+			r, ok := l.readEscape()
+			if !ok && problem == "" && l.r != 0 {
+				if escape == 'u' {
+					problem = "invalid unicode escape " + l.input[start:l.readPosition]
+				} else {
+					problem = "unknown escape " + l.input[start:l.readPosition]
+				}
+			}
+			out.WriteRune(r)
+		default:
+			out.WriteRune(l.r)
+		}
+	}
+}
+
+// readEscape resolves the escape sequence starting at the current rune (the
+// one after the backslash).
+func (l *Lexer) readEscape() (rune, bool) {
+	switch l.r {
+	case 'n':
+		return '\n', true
+	case 't':
+		return '\t', true
+	case 'r':
+		return '\r', true
+	case '"':
+		return '"', true
+	case '\\':
+		return '\\', true
+	case 'u':
+		// \u{1F600}
+		if l.peekRune() != '{' {
+			return 0, false
+		}
+		l.readRune()
+
+		position := l.readPosition
+		for l.peekRune() != '}' && l.peekRune() != 0 {
+			l.readRune()
+		}
+		digits := l.input[position:l.readPosition]
+		l.readRune()
+		if l.r != '}' {
+			return 0, false
+		}
+
+		code, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return 0, false
+		}
+		return rune(code), true
+	default:
+		return 0, false
 	}
-	return l.input[position:l.position]
 }
 
 func isEmoji(r rune) bool {
@@ -15,7 +15,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 if (5 < 10) {
 	return true;
@@ -25,8 +25,15 @@ if (5 < 10) {
 
 10 == 10;
 10 != 9;
+a <= b >= c && d || e;
+a += 1; a -= 1; a *= 2; a /= 2;
+while for in break continue
 
 macro(x, y) { x + y; };
+"foobar"
+"foo bar"
+[1, 2];
+{"foo": "bar"}
 `
 
 	tests := []struct {
@@ -106,6 +113,37 @@ macro(x, y) { x + y; };
 		{token.NOT_EQ, "!="},
 		{token.INT, "9"},
 		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.WHILE, "while"},
+		{token.FOR, "for"},
+		{token.IN, "in"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
 		{token.MACRO, "macro"},
 		{token.LPAREN, "("},
 		{token.IDENT, "x"},
@@ -119,6 +157,19 @@ macro(x, y) { x + y; };
 		{token.SEMICOLON, ";"},
 		{token.RBRACE, "}"},
 		{token.SEMICOLON, ";"},
+		{token.STRING, "foobar"},
+		{token.STRING, "foo bar"},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
+		{token.LBRACE, "{"},
+		{token.STRING, "foo"},
+		{token.COLON, ":"},
+		{token.STRING, "bar"},
+		{token.RBRACE, "}"},
 		{token.EOF, ""},
 	}
 
@@ -194,3 +245,177 @@ let 🙋 = add(5, 10);
 		}
 	}
 }
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{`""`, token.STRING, ""},
+		{`"hello world"`, token.STRING, "hello world"},
+		{`"a\nb"`, token.STRING, "a\nb"},
+		{`"a\tb"`, token.STRING, "a\tb"},
+		{`"say \"hi\""`, token.STRING, `say "hi"`},
+		{`"back\\slash"`, token.STRING, `back\slash`},
+		{`"\u{48}\u{1F600}"`, token.STRING, "H😀"},
+		{`"🐈 and 🐕"`, token.STRING, "🐈 and 🐕"},
+		{`"unterminated`, token.ILLEGAL, UnterminatedString},
+		{`"bad \q escape"`, token.ILLEGAL, `unknown escape \q`},
+		{`"\u{110000}"`, token.ILLEGAL, `invalid unicode escape \u{110000}`},
+		{`"\u{48`, token.ILLEGAL, UnterminatedString},
+		{`"ends in \`, token.ILLEGAL, UnterminatedString},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{"42", token.INT, "42"},
+		{"1.5", token.FLOAT, "1.5"},
+		{"0.25", token.FLOAT, "0.25"},
+		{"1e9", token.FLOAT, "1e9"},
+		{"1e-9", token.FLOAT, "1e-9"},
+		{"2.5E+3", token.FLOAT, "2.5E+3"},
+		// A point or exponent needs digits after it to be part of the number
+		{"1.", token.INT, "1"},
+		{"1.x", token.INT, "1"},
+		{"1else", token.INT, "1"},
+		{"1e+", token.INT, "1"},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 5; // trailing
+/* block /* nested */ still comment */ x /
+2 /* ✓ */ /* unterminated /* */`
+
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedLiteral  string
+		expectedComments []string
+	}{
+		{token.LET, "let", []string{"// leading"}},
+		{token.IDENT, "x", nil},
+		{token.ASSIGN, "=", nil},
+		{token.INT, "5", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "x", []string{"// trailing", "/* block /* nested */ still comment */"}},
+		{token.SLASH, "/", nil},
+		{token.INT, "2", nil},
+		{token.ILLEGAL, UnterminatedComment, []string{"/* ✓ */"}},
+		{token.EOF, "", nil},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if len(tok.Comments) != len(tt.expectedComments) {
+			t.Fatalf("tests[%d] - comments wrong. expected=%q, got=%q",
+				i, tt.expectedComments, tok.Comments)
+		}
+
+		for j, comment := range tt.expectedComments {
+			if tok.Comments[j] != comment {
+				t.Fatalf("tests[%d] - comment[%d] wrong. expected=%q, got=%q",
+					i, j, comment, tok.Comments[j])
+			}
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := `let x = 5;
+  "🐈" + x;
+`
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedOffset int
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, 0, 1, 1},
+		{token.IDENT, 4, 1, 5},
+		{token.ASSIGN, 6, 1, 7},
+		{token.INT, 8, 1, 9},
+		{token.SEMICOLON, 9, 1, 10},
+		{token.STRING, 13, 2, 3},
+		{token.PLUS, 20, 2, 7},
+		{token.IDENT, 22, 2, 9},
+		{token.SEMICOLON, 23, 2, 10},
+		{token.EOF, 25, 3, 1},
+	}
+
+	l := NewFile("test.monkey", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos.Filename != "test.monkey" {
+			t.Fatalf("tests[%d] - filename wrong. expected=%q, got=%q",
+				i, "test.monkey", tok.Pos.Filename)
+		}
+
+		if tok.Pos.Offset != tt.expectedOffset {
+			t.Fatalf("tests[%d] - offset wrong. expected=%d, got=%d",
+				i, tt.expectedOffset, tok.Pos.Offset)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+}
@@ -1,18 +1,171 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"github.com/tzcl/monkey/repl"
+	"io"
 	"os"
 	"os/user"
+
+	"github.com/tzcl/monkey/compiler"
+	"github.com/tzcl/monkey/evaluator"
+	"github.com/tzcl/monkey/lexer"
+	"github.com/tzcl/monkey/object"
+	"github.com/tzcl/monkey/parser"
+	"github.com/tzcl/monkey/repl"
+	"github.com/tzcl/monkey/vm"
+)
+
+const usage = `Usage:
+  monkey [-engine E]              start an interactive repl
+  monkey [-engine E] run FILE     run a Monkey source file ("-" reads stdin)
+  monkey [-engine E] -e EXPR      evaluate EXPR and print the result
+
+Engines are "eval" (tree-walking evaluator, the default) and "vm" (bytecode
+compiler and virtual machine).
+`
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1 // parse or runtime error in the Monkey program
+	exitUsage = 2
 )
 
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("monkey", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+
+	expr := flags.String("e", "", "evaluate `EXPR` and print the result")
+	engineName := flags.String("engine", string(repl.EVAL), "`ENGINE` to run programs with")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	defer func(w io.Writer) { object.Stdout = w }(object.Stdout)
+	object.Stdout = stdout
+
+	engine := repl.Engine(*engineName)
+	if engine != repl.EVAL && engine != repl.VM {
+		fmt.Fprintf(stderr, "monkey: unknown engine %q\n", *engineName)
+		return exitUsage
+	}
+
+	exprSet := false
+	flags.Visit(func(f *flag.Flag) { exprSet = exprSet || f.Name == "e" })
+
+	if exprSet {
+		if flags.NArg() != 0 {
+			flags.Usage()
+			return exitUsage
+		}
+		return execute(engine, "-e", *expr, true, stdout, stderr)
+	}
+
+	switch flags.Arg(0) {
+	case "":
+		startRepl(stdin, stdout, engine)
+		return exitOK
+	case "run":
+		if flags.NArg() != 2 {
+			flags.Usage()
+			return exitUsage
+		}
+		return runFile(engine, flags.Arg(1), stdin, stdout, stderr)
+	default:
+		flags.Usage()
+		return exitUsage
+	}
+}
+
+func startRepl(in io.Reader, out io.Writer, engine repl.Engine) {
 	user, err := user.Current()
 	if err != nil {
 		panic(err) // Couldn't get a user
 	}
-	fmt.Printf("Hello %s!\n", user.Username)
-	fmt.Printf("This repl will parse any input you type in\n")
-	repl.Start(os.Stdin, os.Stdout)
+	fmt.Fprintf(out, "Hello %s!\n", user.Username)
+	fmt.Fprintf(out, "This repl will parse any input you type in (:help for commands)\n")
+	repl.Start(in, out, engine)
+}
+
+func runFile(engine repl.Engine, filename string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var src []byte
+	var err error
+
+	if filename == "-" {
+		src, err = io.ReadAll(stdin)
+	} else {
+		src, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitError
+	}
+
+	return execute(engine, filename, string(src), false, stdout, stderr)
+}
+
+// execute runs src as a whole program. Parser errors and runtime errors are
+// written to stderr; if printResult is set the program's value is written to
+// stdout.
+func execute(engine repl.Engine, filename, src string, printResult bool, stdout, stderr io.Writer) int {
+	l := lexer.NewFile(filename, src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintln(stderr, msg)
+		}
+		return exitError
+	}
+
+	macroEnv := object.NewEnvironment()
+
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, macroErrors := evaluator.ExpandMacros(program, macroEnv)
+	if len(macroErrors) != 0 {
+		for _, err := range macroErrors {
+			fmt.Fprintln(stderr, err)
+		}
+		return exitError
+	}
+
+	var result object.Object
+
+	switch engine {
+	case repl.VM:
+		comp := compiler.New()
+		if err := comp.Compile(expanded); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+
+		machine := vm.New(comp.Bytecode())
+		if err := machine.Run(); err != nil {
+			fmt.Fprintf(stderr, "ERROR: %s\n", err)
+			return exitError
+		}
+
+		if repl.EndsWithValue(program) {
+			result = machine.LastPoppedStackElem()
+		}
+	default:
+		result = evaluator.Eval(expanded, object.NewEnvironment())
+		if err, ok := result.(*object.Error); ok {
+			fmt.Fprintln(stderr, err.Inspect())
+			return exitError
+		}
+	}
+
+	if printResult && result != nil && result.Type() != object.NULL_OBJ {
+		fmt.Fprintln(stdout, result.Inspect())
+	}
+
+	return exitOK
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "program.monkey")
+	if err := os.WriteFile(file, []byte(`puts("from file"); 1 + 1`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args   []string
+		stdin  string
+		code   int
+		stdout string
+		stderr string // substring of the expected stderr
+	}{
+		{[]string{"-e", "1 + 2"}, "", exitOK, "3\n", ""},
+		{[]string{"-engine", "vm", "-e", "1 + 2"}, "", exitOK, "3\n", ""},
+		{[]string{"-e", "puts(1)"}, "", exitOK, "1\n", ""},
+		{[]string{"-engine", "vm", "-e", "puts(1)"}, "", exitOK, "1\n", ""},
+		{[]string{"-e", "let x = 1"}, "", exitOK, "", ""},
+		{[]string{"-e", "1 +"}, "", exitError, "", "no prefix parse function"},
+		{[]string{"-e", "missing"}, "", exitError, "", "identifier not found: missing"},
+		{[]string{"-engine", "vm", "-e", "missing"}, "", exitError, "", "identifier not found: missing"},
+		{[]string{"run", file}, "", exitOK, "from file\n", ""},
+		{[]string{"-engine", "vm", "run", file}, "", exitOK, "from file\n", ""},
+		{[]string{"run", "-"}, `puts("from stdin")`, exitOK, "from stdin\n", ""},
+		{[]string{"run", filepath.Join(t.TempDir(), "missing")}, "", exitError, "", "no such file"},
+		{[]string{"-engine", "lisp", "-e", "1"}, "", exitUsage, "", `unknown engine "lisp"`},
+		{[]string{"run"}, "", exitUsage, "", "Usage:"},
+		{[]string{"-e", "1", "extra"}, "", exitUsage, "", "Usage:"},
+		{[]string{"compile", file}, "", exitUsage, "", "Usage:"},
+		{[]string{"-bogus"}, "", exitUsage, "", "Usage:"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if code != tt.code {
+			t.Errorf("%v: wrong exit code. want=%d, got=%d (stderr=%q)", tt.args, tt.code, code, stderr.String())
+		}
+		if stdout.String() != tt.stdout {
+			t.Errorf("%v: wrong stdout. want=%q, got=%q", tt.args, tt.stdout, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), tt.stderr) {
+			t.Errorf("%v: stderr doesn't contain %q. got=%q", tt.args, tt.stderr, stderr.String())
+		}
+	}
+}
+
+func TestRunRepl(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-engine", "vm"}, strings.NewReader("let x = 2\nputs(x)\n"), &stdout, &stderr)
+
+	if code != exitOK {
+		t.Errorf("wrong exit code. want=%d, got=%d", exitOK, code)
+	}
+	if !strings.Contains(stdout.String(), ">> >> 2\nnull\n>> ") {
+		t.Errorf("repl output wrong. got=%q", stdout.String())
+	}
+}
+
+// TestEnginesAgree runs programs with both engines and checks that they
+// print and fail the same way.
+func TestEnginesAgree(t *testing.T) {
+	programs := []string{
+		"let i = 0; while (i < 3) { i += 1; if (i == 2) { break; } }; i",
+		"let r = []; for (x in [1, 2, 3]) { if (x == 2) { continue; } r = push(r, x) }; r",
+		"let i = 0; while (i < 3) { i += 1; let y = if (true) { break; }; }; i",
+		"for (x in [1, 2, 3]) { puts(x, if (x == 2) { continue; } else { x }) }",
+		"let r = 0; for (x in [1, 2, 3]) { r = [r, if (x == 2) { break; } else { x }] }; r",
+		"let fs = []; for (x in [1, 2, 3]) { fs = push(fs, fn() { x }) }; fs[0]()",
+		"let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x += 10 }) }; [fs[0](), fs[0](), fs[1]()]",
+		"let x = 10; for (x in [1, 2]) { let y = x }; x",
+	}
+
+	for _, program := range programs {
+		var want, got bytes.Buffer
+		wantCode := run([]string{"-engine", "eval", "-e", program}, strings.NewReader(""), &want, &want)
+		gotCode := run([]string{"-engine", "vm", "-e", program}, strings.NewReader(""), &got, &got)
+
+		if gotCode != wantCode || got.String() != want.String() {
+			t.Errorf("engines disagree on %q.\neval (%d): %q\nvm (%d):   %q",
+				program, wantCode, want.String(), gotCode, got.String())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Package monkey embeds the Monkey interpreter in Go programs.
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/tzcl/monkey/evaluator"
+	"github.com/tzcl/monkey/lexer"
+	"github.com/tzcl/monkey/object"
+	"github.com/tzcl/monkey/parser"
+)
+
+// Interpreter runs Monkey programs with the tree-walking evaluator. Bindings
+// and macros made by one program are visible to the next, like in the REPL.
+//
+// An Interpreter must not be used by more than one goroutine at a time.
+type Interpreter struct {
+	// Limits bounds every program run by the interpreter.
+	Limits evaluator.Limits
+
+	// HygienicMacros expands macros with evaluator.ExpandMacrosHygienic.
+	HygienicMacros bool
+
+	env      *object.Environment
+	macroEnv *object.Environment
+}
+
+// New returns an Interpreter with nothing bound but the builtins.
+func New() *Interpreter {
+	return &Interpreter{
+		env:      object.NewEnvironment(),
+		macroEnv: object.NewEnvironment(),
+	}
+}
+
+// ParseError is returned for programs that don't parse.
+type ParseError struct {
+	Errors []string
+}
+
+func (e *ParseError) Error() string {
+	return strings.Join(e.Errors, "\n")
+}
+
+// MacroError is returned for programs with macro calls that can't be
+// expanded.
+type MacroError struct {
+	Errors []*evaluator.MacroError
+}
+
+func (e *MacroError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Eval runs the program src and returns its value. Programs that fail to
+// parse return a *ParseError, programs whose macros can't be expanded return
+// a *MacroError, and programs that fail at runtime return the *object.Error
+// they stopped with.
+func (i *Interpreter) Eval(src string) (object.Object, error) {
+	return i.EvalContext(context.Background(), src)
+}
+
+// EvalContext is Eval for a program that stops with an error once ctx is done.
+func (i *Interpreter) EvalContext(ctx context.Context, src string) (object.Object, error) {
+	p := parser.New(lexer.New(src))
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.Errors()}
+	}
+
+	// Macros run as part of the program, so expanding them spends from the
+	// same budget as evaluating the result
+	budget := evaluator.NewBudget(ctx, i.Limits)
+	i.macroEnv.SetBudget(budget)
+	defer i.macroEnv.SetBudget(nil)
+
+	evaluator.DefineMacros(program, i.macroEnv)
+	expand := evaluator.ExpandMacros
+	if i.HygienicMacros {
+		expand = evaluator.ExpandMacrosHygienic
+	}
+
+	expanded, macroErrors := expand(program, i.macroEnv)
+	if len(macroErrors) != 0 {
+		return nil, &MacroError{Errors: macroErrors}
+	}
+
+	i.env.SetBudget(budget)
+	defer i.env.SetBudget(nil)
+
+	result := evaluator.Eval(expanded, i.env)
+	if err, ok := result.(*object.Error); ok {
+		return nil, err
+	}
+
+	// Programs ending in a statement without a value, like let, are null
+	if result == nil {
+		return evaluator.NULL, nil
+	}
+
+	return result, nil
+}
+
+// Set binds name to value in the global environment. Go values are converted
+// with object.FromGo.
+func (i *Interpreter) Set(name string, value any) error {
+	obj, err := object.FromGo(value)
+	if err != nil {
+		return fmt.Errorf("monkey: cannot set %s: %w", name, err)
+	}
+
+	i.env.Set(name, obj)
+	return nil
+}
+
+// Get returns the value bound to name in the global environment.
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	return i.env.Get(name)
+}
+
+// RegisterFunc makes the Go function fn callable from programs as name. Unlike
+// evaluator.RegisterBuiltin it only affects this interpreter, and programs
+// can rebind name like any other global.
+//
+// Functions of type func(args ...object.Object) object.Object are called
+// with their arguments as they are. Any other function has its arguments and
+// results converted as described by object.FromGo.
+func (i *Interpreter) RegisterFunc(name string, fn any) error {
+	if reflect.TypeOf(fn) == nil || reflect.TypeOf(fn).Kind() != reflect.Func {
+		return fmt.Errorf("monkey: cannot register %s: %T is not a function", name, fn)
+	}
+	return i.Set(name, fn)
+}
@@ -0,0 +1,313 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tzcl/monkey/evaluator"
+	"github.com/tzcl/monkey/object"
+)
+
+func TestEval(t *testing.T) {
+	interp := New()
+
+	result, err := interp.Eval("let double = fn(x) { x * 2 };")
+	if err != nil {
+		t.Fatalf("Eval returned error: %s", err)
+	}
+	if result != evaluator.NULL {
+		t.Errorf("let statement didn't evaluate to null. got=%s", result.Inspect())
+	}
+
+	// Bindings carry over to later programs
+	result, err = interp.Eval("double(21)")
+	if err != nil {
+		t.Fatalf("Eval returned error: %s", err)
+	}
+	testInteger(t, result, 42)
+
+	// So do macros
+	_, err = interp.Eval("let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) };")
+	if err != nil {
+		t.Fatalf("Eval returned error: %s", err)
+	}
+	result, err = interp.Eval("unless(false, 1, 2)")
+	if err != nil {
+		t.Fatalf("Eval returned error: %s", err)
+	}
+	testInteger(t, result, 1)
+}
+
+func TestEvalErrors(t *testing.T) {
+	interp := New()
+
+	_, err := interp.Eval("let = 5;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected *ParseError. got=%T (%v)", err, err)
+	}
+	if len(parseErr.Errors) == 0 {
+		t.Errorf("parse error has no messages")
+	}
+
+	_, err = interp.Eval("let m = macro(x) { x }; m(1, 2)")
+	var macroErr *MacroError
+	if !errors.As(err, &macroErr) {
+		t.Fatalf("expected *MacroError. got=%T (%v)", err, err)
+	}
+	if err.Error() != "1:25: cannot expand macro m: wrong number of arguments: want=1, got=2" {
+		t.Errorf("wrong error. got=%q", err.Error())
+	}
+
+	_, err = interp.Eval("1 + true")
+	var runtimeErr *object.Error
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *object.Error. got=%T (%v)", err, err)
+	}
+	if err.Error() != "1:1: type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("wrong error. got=%q", err.Error())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	interp := New()
+	interp.Limits = evaluator.Limits{Steps: 1000}
+
+	_, err := interp.Eval("while (true) {}")
+	if err == nil || err.(*object.Error).Message != evaluator.StepLimitMessage {
+		t.Errorf("expected step limit error. got=%v", err)
+	}
+
+	// Each program gets a fresh budget
+	result, err := interp.Eval("1 + 1")
+	if err != nil {
+		t.Fatalf("Eval returned error: %s", err)
+	}
+	testInteger(t, result, 2)
+
+	// Interpreters don't share limits
+	shallow, deep := New(), New()
+	shallow.Limits = evaluator.Limits{CallDepth: 10}
+	deep.Limits = evaluator.Limits{CallDepth: 100}
+
+	const nested = "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(50)"
+	if _, err := shallow.Eval(nested); err == nil || err.(*object.Error).Message != "maximum call depth of 10 exceeded" {
+		t.Errorf("expected call depth error. got=%v", err)
+	}
+	result, err = deep.Eval(nested)
+	if err != nil {
+		t.Fatalf("Eval returned error: %s", err)
+	}
+	testInteger(t, result, 50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	interp.Limits = evaluator.Limits{}
+	_, err = interp.EvalContext(ctx, "while (true) {}")
+	if err == nil || err.(*object.Error).Message != evaluator.CancelledMessage {
+		t.Errorf("expected cancelled error. got=%v", err)
+	}
+}
+
+func TestLimitsApplyToMacros(t *testing.T) {
+	const src = "let m = macro() { while (true) {} }; m()"
+
+	tests := []struct {
+		limits  evaluator.Limits
+		message string
+	}{
+		{evaluator.Limits{Steps: 1000}, evaluator.StepLimitMessage},
+		{evaluator.Limits{}, evaluator.CancelledMessage},
+	}
+
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		interp := New()
+		interp.Limits = tt.limits
+
+		_, err := interp.EvalContext(ctx, src)
+		macroErr, ok := err.(*MacroError)
+		if !ok {
+			t.Fatalf("expected MacroError. got=%T (%v)", err, err)
+		}
+		if !strings.HasSuffix(macroErr.Error(), tt.message) {
+			t.Errorf("wrong error. want suffix %q, got=%q", tt.message, macroErr.Error())
+		}
+	}
+
+	// Expansion and evaluation share one budget
+	interp := New()
+	interp.Limits = evaluator.Limits{Steps: 1000}
+	_, err := interp.Eval(`let m = macro() { let i = 0; while (i < 80) { i += 1 }; quote(1) }; let i = 0; while (i < 80) { i += 1 }; m()`)
+	if err == nil || err.(*object.Error).Message != evaluator.StepLimitMessage {
+		t.Errorf("expected step limit error. got=%v", err)
+	}
+}
+
+func TestSetAndGet(t *testing.T) {
+	interp := New()
+
+	tests := []struct {
+		value    any
+		expected string
+	}{
+		{5, "5"},
+		{int64(-3), "-3"},
+		{1.5, "1.5"},
+		{"hi", "hi"},
+		{true, "true"},
+		{nil, "null"},
+		{&object.Array{Elements: []object.Object{&object.Integer{Value: 1}}}, "[1]"},
+	}
+
+	for _, tt := range tests {
+		if err := interp.Set("x", tt.value); err != nil {
+			t.Errorf("Set(%v) returned error: %s", tt.value, err)
+			continue
+		}
+
+		result, err := interp.Eval("x")
+		if err != nil {
+			t.Errorf("Eval returned error: %s", err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong value for %v. want=%q, got=%q", tt.value, tt.expected, result.Inspect())
+		}
+	}
+
+	// Booleans from Go compare equal to Monkey's own
+	interp.Set("yes", true)
+	result, _ := interp.Eval("yes == (1 < 2)")
+	if result != evaluator.TRUE {
+		t.Errorf("Go true isn't equal to Monkey true. got=%s", result.Inspect())
+	}
+
+	if err := interp.Set("x", make(chan int)); err == nil {
+		t.Errorf("expected error setting unsupported type")
+	}
+
+	interp.Eval("let y = 10;")
+	obj, ok := interp.Get("y")
+	if !ok {
+		t.Fatalf("y not bound")
+	}
+	testInteger(t, obj, 10)
+
+	if _, ok := interp.Get("undefined"); ok {
+		t.Errorf("Get found unbound name")
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	interp := New()
+
+	var calls int
+	interp.RegisterFunc("add", func(args ...object.Object) object.Object {
+		calls++
+		sum := int64(0)
+		for _, arg := range args {
+			integer, ok := arg.(*object.Integer)
+			if !ok {
+				return &object.Error{Message: "add takes integers, got " + string(arg.Type())}
+			}
+			sum += integer.Value
+		}
+		return &object.Integer{Value: sum}
+	})
+
+	result, err := interp.Eval("add(1, 2, add(3, 4))")
+	if err != nil {
+		t.Fatalf("Eval returned error: %s", err)
+	}
+	testInteger(t, result, 10)
+	if calls != 2 {
+		t.Errorf("wrong number of calls. got=%d", calls)
+	}
+
+	_, err = interp.Eval(`add(1, "two")`)
+	if err == nil || err.Error() != "1:1: add takes integers, got STRING" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+
+	// Registered functions belong to one interpreter
+	if _, err := New().Eval("add(1, 2)"); err == nil {
+		t.Errorf("function registered on another interpreter was callable")
+	}
+}
+
+func TestRegisterGoFunc(t *testing.T) {
+	type point struct{ X, Y int }
+
+	interp := New()
+	interp.RegisterFunc("add", func(p point) point { return point{p.X + 1, p.Y + 1} })
+	interp.RegisterFunc("parse", func(s string) (int, error) {
+		if s == "" {
+			return 0, errors.New("empty input")
+		}
+		return len(s), nil
+	})
+	interp.Set("origin", point{})
+
+	result, err := interp.Eval(`let p = add(origin); p["X"] + p["Y"]`)
+	if err != nil {
+		t.Fatalf("Eval returned error: %s", err)
+	}
+	testInteger(t, result, 2)
+
+	_, err = interp.Eval(`parse("")`)
+	if err == nil || err.Error() != "1:1: empty input" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+
+	if err := interp.RegisterFunc("five", 5); err == nil {
+		t.Errorf("registering a non-function did not fail")
+	}
+
+	var p point
+	obj, _ := interp.Get("p")
+	if err := object.ToGo(obj, &p); err != nil || p != (point{1, 1}) {
+		t.Errorf("wrong point. got=%+v (%v)", p, err)
+	}
+}
+
+func TestHygienicMacros(t *testing.T) {
+	src := `
+	let plusTmp = macro(x) { quote(if (true) { let tmp = 10; tmp + unquote(x) }) };
+	let tmp = 1;
+	plusTmp(tmp)
+	`
+
+	for _, tt := range []struct {
+		hygienic bool
+		expected int64
+	}{{false, 20}, {true, 11}} {
+		interp := New()
+		interp.HygienicMacros = tt.hygienic
+
+		result, err := interp.Eval(src)
+		if err != nil {
+			t.Fatalf("Eval returned error: %s", err)
+		}
+		testInteger(t, result, tt.expected)
+	}
+}
+
+func testInteger(t *testing.T, obj object.Object, expected int64) {
+	t.Helper()
+
+	integer, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("object is not Integer. got=%T (%+v)", obj, obj)
+		return
+	}
+	if integer.Value != expected {
+		t.Errorf("wrong value. want=%d, got=%d", expected, integer.Value)
+	}
+}
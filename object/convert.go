@@ -0,0 +1,461 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// Go values convert to Monkey objects as follows:
+//
+//   - nil, and nil pointers, interfaces and functions, become NULL
+//   - booleans, integers, floats and strings become the matching object;
+//     integers too large for an int64 become a BigInt, as does *big.Int
+//   - slices and arrays become an Array
+//   - maps become a Hash, as long as their keys convert to hashable objects
+//   - structs become a Hash from field names to values. Unexported fields are
+//     skipped, and a `monkey:"name"` tag renames a field, or skips it if the
+//     name is "-"
+//   - pointers and interfaces become whatever they point to
+//   - functions become a Builtin that converts its arguments to Go and its
+//     result back. Functions may return nothing, a value, an error, or a value
+//     and an error; a non-nil error is turned into an Error, and so is a
+//     panic.
+//
+// Values that contain themselves, like a struct pointing to itself, can't be
+// converted.
+//
+// Objects are left as they are. ToGo converts in the other direction.
+
+var (
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	bigIntType = reflect.TypeOf((*big.Int)(nil))
+)
+
+// FromGo converts value to a Monkey object.
+func FromGo(value any) (Object, error) {
+	switch value := value.(type) {
+	case Object:
+		return value, nil
+	case BuiltinFunction:
+		return &Builtin{Fn: recoverPanics(value)}, nil
+	case func(args ...Object) Object:
+		return &Builtin{Fn: recoverPanics(value)}, nil
+	}
+
+	return fromGo(reflect.ValueOf(value), visited{})
+}
+
+// visited holds the pointers, maps and slices fromGo is in the middle of
+// converting. Coming across one of them again means a value contains itself.
+type visited map[reference]bool
+
+type reference struct {
+	ptr uintptr
+	typ reflect.Type
+	len int // slices of different lengths can share a pointer
+}
+
+func fromGo(v reflect.Value, seen visited) (Object, error) {
+	if !v.IsValid() {
+		return NULL, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+	}
+
+	if obj, ok := v.Interface().(Object); ok {
+		return obj, nil
+	}
+
+	if v.Type() == bigIntType {
+		return NewInteger(new(big.Int).Set(v.Interface().(*big.Int))), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		ref := reference{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			ref.len = v.Len()
+		}
+		if seen[ref] {
+			return nil, fmt.Errorf("cannot convert %s to a Monkey value: it contains itself", v.Type())
+		}
+		seen[ref] = true
+		defer delete(seen, ref)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return boolean(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return &BigInt{Value: new(big.Int).SetUint64(v.Uint())}, nil
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			element, err := fromGo(v.Index(i), seen)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		pairs := make(map[HashKey]HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := addPair(pairs, iter.Key(), iter.Value(), seen); err != nil {
+				return nil, err
+			}
+		}
+		return &Hash{Pairs: pairs}, nil
+	case reflect.Struct:
+		pairs := make(map[HashKey]HashPair)
+		for i := 0; i < v.NumField(); i++ {
+			name, ok := fieldName(v.Type().Field(i))
+			if !ok {
+				continue
+			}
+			if err := addPair(pairs, reflect.ValueOf(name), v.Field(i), seen); err != nil {
+				return nil, err
+			}
+		}
+		return &Hash{Pairs: pairs}, nil
+	case reflect.Pointer, reflect.Interface:
+		return fromGo(v.Elem(), seen)
+	case reflect.Func:
+		return goFunction(v)
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a Monkey value", v.Type())
+	}
+}
+
+func addPair(pairs map[HashKey]HashPair, k, v reflect.Value, seen visited) error {
+	key, err := fromGo(k, seen)
+	if err != nil {
+		return err
+	}
+
+	hashKey, ok := key.(Hashable)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", key.Type())
+	}
+
+	value, err := fromGo(v, seen)
+	if err != nil {
+		return err
+	}
+
+	pairs[hashKey.HashKey()] = HashPair{Key: key, Value: value}
+	return nil
+}
+
+// fieldName returns the hash key for a struct field, reporting false if the
+// field is left out.
+func fieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+
+	switch tag := field.Tag.Get("monkey"); tag {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	default:
+		return tag, true
+	}
+}
+
+func boolean(value bool) *Boolean {
+	if value {
+		return TRUE
+	}
+	return FALSE
+}
+
+// goFunction wraps the Go function fn in a Builtin.
+func goFunction(fn reflect.Value) (Object, error) {
+	t := fn.Type()
+
+	switch {
+	case t.NumOut() > 2, t.NumOut() == 2 && t.Out(1) != errorType:
+		return nil, fmt.Errorf("cannot convert %s to a Monkey value: functions must return at most a value and an error", t)
+	}
+
+	return &Builtin{Fn: recoverPanics(func(args ...Object) Object {
+		in, err := goArguments(t, args)
+		if err != nil {
+			return err
+		}
+		return goResult(fn.Call(in))
+	})}, nil
+}
+
+// recoverPanics wraps fn so that a panic in it becomes an Error, rather than
+// unwinding through the interpreter and taking down the program embedding it.
+func recoverPanics(fn BuiltinFunction) BuiltinFunction {
+	return func(args ...Object) (result Object) {
+		defer func() {
+			if r := recover(); r != nil {
+				result = newError("Go function panicked: %v", r)
+			}
+		}()
+		return fn(args...)
+	}
+}
+
+func goArguments(t reflect.Type, args []Object) ([]reflect.Value, *Error) {
+	numIn := t.NumIn()
+
+	if t.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, newError("wrong number of arguments. got=%d, want at least %d", len(args), numIn-1)
+		}
+	} else if len(args) != numIn {
+		return nil, newError("wrong number of arguments. got=%d, want=%d", len(args), numIn)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			paramType = t.In(numIn - 1).Elem()
+		} else {
+			paramType = t.In(i)
+		}
+
+		in[i] = reflect.New(paramType).Elem()
+		if err := toGo(arg, in[i]); err != nil {
+			return nil, newError("argument %d: %s", i+1, err)
+		}
+	}
+
+	return in, nil
+}
+
+func goResult(out []reflect.Value) Object {
+	if n := len(out); n > 0 && out[n-1].Type() == errorType {
+		if err := out[n-1]; !err.IsNil() {
+			return newError("%s", err.Interface().(error))
+		}
+		out = out[:n-1]
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	result, err := fromGo(out[0], visited{})
+	if err != nil {
+		return newError("%s", err)
+	}
+	return result
+}
+
+// ToGo converts obj to a Go value and stores it in the value target points
+// to. It is the inverse of FromGo, with a Hash filling in the fields of a
+// struct that it has keys for. Converting to an empty interface picks a Go
+// type for obj: int64, *big.Int, float64, string, bool, nil, []any or
+// map[any]any, or obj itself for functions and other objects without one.
+func ToGo(obj Object, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return errors.New("ToGo target must be a non-nil pointer")
+	}
+	return toGo(obj, v.Elem())
+}
+
+func toGo(obj Object, v reflect.Value) error {
+	t := v.Type()
+
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		value := goValue(obj)
+		if value == nil {
+			v.Set(reflect.Zero(t))
+		} else {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	}
+
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+
+	if obj == NULL {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+	}
+
+	if t == bigIntType && IsInteger(obj) {
+		v.Set(reflect.ValueOf(new(big.Int).Set(ToBigInt(obj))))
+		return nil
+	}
+
+	cannotConvert := fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := obj.(*Boolean)
+		if !ok {
+			return cannotConvert
+		}
+		v.SetBool(b.Value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !IsInteger(obj) {
+			return cannotConvert
+		}
+		i := ToBigInt(obj)
+		if !i.IsInt64() || v.OverflowInt(i.Int64()) {
+			return fmt.Errorf("%s overflows %s", i, t)
+		}
+		v.SetInt(i.Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if !IsInteger(obj) {
+			return cannotConvert
+		}
+		i := ToBigInt(obj)
+		if !i.IsUint64() || v.OverflowUint(i.Uint64()) {
+			return fmt.Errorf("%s overflows %s", i, t)
+		}
+		v.SetUint(i.Uint64())
+	case reflect.Float32, reflect.Float64:
+		switch {
+		case obj.Type() == FLOAT_OBJ:
+			v.SetFloat(obj.(*Float).Value)
+		case IsInteger(obj):
+			v.SetFloat(IntegerToFloat(obj))
+		default:
+			return cannotConvert
+		}
+	case reflect.String:
+		s, ok := obj.(*String)
+		if !ok {
+			return cannotConvert
+		}
+		v.SetString(s.Value)
+	case reflect.Slice:
+		arr, ok := obj.(*Array)
+		if !ok {
+			return cannotConvert
+		}
+		slice := reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+		if err := elementsToGo(arr.Elements, slice); err != nil {
+			return err
+		}
+		v.Set(slice)
+	case reflect.Array:
+		arr, ok := obj.(*Array)
+		if !ok || len(arr.Elements) != t.Len() {
+			return cannotConvert
+		}
+		return elementsToGo(arr.Elements, v)
+	case reflect.Map:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return cannotConvert
+		}
+		m := reflect.MakeMapWithSize(t, len(hash.Pairs))
+		for _, pair := range hash.Pairs {
+			key := reflect.New(t.Key()).Elem()
+			if err := toGo(pair.Key, key); err != nil {
+				return err
+			}
+			value := reflect.New(t.Elem()).Elem()
+			if err := toGo(pair.Value, value); err != nil {
+				return fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return cannotConvert
+		}
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := fieldName(t.Field(i))
+			if !ok {
+				continue
+			}
+			pair, ok := hash.Pairs[(&String{Value: name}).HashKey()]
+			if !ok {
+				continue
+			}
+			if err := toGo(pair.Value, v.Field(i)); err != nil {
+				return fmt.Errorf("field %s: %w", name, err)
+			}
+		}
+	case reflect.Pointer:
+		p := reflect.New(t.Elem())
+		if err := toGo(obj, p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+	default:
+		return cannotConvert
+	}
+
+	return nil
+}
+
+func elementsToGo(elements []Object, v reflect.Value) error {
+	for i, element := range elements {
+		if err := toGo(element, v.Index(i)); err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// goValue returns the Go value an object converts to by default.
+func goValue(obj Object) any {
+	switch obj := obj.(type) {
+	case *Integer:
+		return obj.Value
+	case *BigInt:
+		return new(big.Int).Set(obj.Value)
+	case *Float:
+		return obj.Value
+	case *String:
+		return obj.Value
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return nil
+	case *Array:
+		values := make([]any, len(obj.Elements))
+		for i, element := range obj.Elements {
+			values[i] = goValue(element)
+		}
+		return values
+	case *Hash:
+		values := make(map[any]any, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			values[goValue(pair.Key)] = goValue(pair.Value)
+		}
+		return values
+	default:
+		return obj
+	}
+}
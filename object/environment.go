@@ -1,8 +1,26 @@
 package object
 
+import (
+	"context"
+	"sort"
+
+	"github.com/tzcl/monkey/token"
+)
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.frame = outer.frame
+	env.budget = outer.budget
+	return env
+}
+
+// NewCallEnvironment returns the environment for a call made from caller to a
+// function closed over outer, running in frame.
+func NewCallEnvironment(outer, caller *Environment, frame *CallFrame) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.frame = frame
+	env.budget = caller.budget
 	return env
 }
 
@@ -12,8 +30,48 @@ func NewEnvironment() *Environment {
 }
 
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	outer  *Environment
+	frame  *CallFrame
+	budget *Budget
+}
+
+// CallFrame is a call to a Monkey function that hasn't returned yet. Frames
+// link back to their callers, making up the Monkey call stack.
+type CallFrame struct {
+	Function string         // name of the called function, empty if anonymous
+	Pos      token.Position // where the function was called
+	Caller   *CallFrame     // nil for calls made from the top level
+	Depth    int            // number of frames on the stack, including this one
+}
+
+// Frame returns the call frame code in e runs in, or nil at the top level.
+func (e *Environment) Frame() *CallFrame {
+	return e.frame
+}
+
+// Budget bounds the work done by an evaluation. It is shared by every
+// environment the evaluation runs code in.
+type Budget struct {
+	Context context.Context // evaluation stops once this is done
+	Steps   int             // steps left, negative if unlimited
+	Memory  int             // bytes left to allocate, negative if unlimited
+
+	// CallDepth is how deeply calls may nest, or the evaluator's default if
+	// not positive
+	CallDepth int
+}
+
+// Budget returns the budget of the evaluation running in e, or nil if it is
+// unbounded.
+func (e *Environment) Budget() *Budget {
+	return e.budget
+}
+
+// SetBudget makes code run in e, and in environments created from it, spend
+// budget. A nil budget removes the bounds.
+func (e *Environment) SetBudget(budget *Budget) {
+	e.budget = budget
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -28,3 +86,26 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign updates an existing binding, looking through the outer environments
+// for it. It reports false if name isn't bound anywhere.
+func (e *Environment) Assign(name string, val Object) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns the sorted names bound directly in this environment, ignoring
+// any outer environments.
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,28 @@
+package object
+
+// Arithmetic and comparisons that mix integers and floats are done on floats,
+// so integers are promoted to the nearest float first.
+
+// IsNumber reports whether obj is an Integer, a BigInt or a Float.
+func IsNumber(obj Object) bool {
+	return IsInteger(obj) || obj.Type() == FLOAT_OBJ
+}
+
+// PromotesToFloat reports whether an operation on left and right is done on
+// floats, i.e. whether both are numbers and at least one is a Float.
+func PromotesToFloat(left, right Object) bool {
+	return IsNumber(left) && IsNumber(right) &&
+		(left.Type() == FLOAT_OBJ || right.Type() == FLOAT_OBJ)
+}
+
+// ToFloat returns the value of a number as a float64.
+func ToFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer, *BigInt:
+		return IntegerToFloat(obj)
+	case *Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,141 @@
+package object
+
+import (
+	"math"
+	"math/big"
+)
+
+// Integer arithmetic is checked for overflow. Results that don't fit in an
+// int64 are promoted to a BigInt, and BigInt results that do fit are demoted
+// back to an Integer, so there is only ever one representation of a value.
+
+// IsInteger reports whether obj is an Integer or a BigInt.
+func IsInteger(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == BIGINT_OBJ
+}
+
+// NewInteger returns x as an Integer if it fits in an int64, otherwise as a
+// BigInt.
+func NewInteger(x *big.Int) Object {
+	if x.IsInt64() {
+		return &Integer{Value: x.Int64()}
+	}
+	return &BigInt{Value: x}
+}
+
+// ToBigInt returns the value of an Integer or BigInt as a big.Int. The result
+// must not be modified.
+func ToBigInt(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInt:
+		return obj.Value
+	default:
+		return nil
+	}
+}
+
+func AddIntegers(left, right Object) Object {
+	l, r, ok := int64Operands(left, right)
+	if ok {
+		sum := l + r
+		if (l^sum)&(r^sum) >= 0 {
+			return &Integer{Value: sum}
+		}
+	}
+	return NewInteger(new(big.Int).Add(ToBigInt(left), ToBigInt(right)))
+}
+
+func SubIntegers(left, right Object) Object {
+	l, r, ok := int64Operands(left, right)
+	if ok {
+		diff := l - r
+		if (l^r)&(l^diff) >= 0 {
+			return &Integer{Value: diff}
+		}
+	}
+	return NewInteger(new(big.Int).Sub(ToBigInt(left), ToBigInt(right)))
+}
+
+func MulIntegers(left, right Object) Object {
+	l, r, ok := int64Operands(left, right)
+	if ok {
+		if l == 0 || r == 0 {
+			return &Integer{Value: 0}
+		}
+
+		product := l * r
+		overflowed := product/r != l ||
+			(l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64)
+		if !overflowed {
+			return &Integer{Value: product}
+		}
+	}
+	return NewInteger(new(big.Int).Mul(ToBigInt(left), ToBigInt(right)))
+}
+
+// DivIntegers divides left by right, truncating towards zero. It reports false
+// if right is zero.
+func DivIntegers(left, right Object) (Object, bool) {
+	l, r, ok := int64Operands(left, right)
+	if ok {
+		if r == 0 {
+			return nil, false
+		}
+		if !(l == math.MinInt64 && r == -1) {
+			return &Integer{Value: l / r}, true
+		}
+	}
+
+	divisor := ToBigInt(right)
+	if divisor.Sign() == 0 {
+		return nil, false
+	}
+	return NewInteger(new(big.Int).Quo(ToBigInt(left), divisor)), true
+}
+
+func NegateInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+	return NewInteger(new(big.Int).Neg(ToBigInt(obj)))
+}
+
+// CompareIntegers returns -1, 0 or +1 depending on whether left is less than,
+// equal to or greater than right.
+func CompareIntegers(left, right Object) int {
+	l, r, ok := int64Operands(left, right)
+	if ok {
+		switch {
+		case l < r:
+			return -1
+		case l > r:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return ToBigInt(left).Cmp(ToBigInt(right))
+}
+
+// IntegerToFloat converts an Integer or BigInt to the nearest float64.
+func IntegerToFloat(obj Object) float64 {
+	if i, ok := obj.(*Integer); ok {
+		return float64(i.Value)
+	}
+	f, _ := new(big.Float).SetInt(ToBigInt(obj)).Float64()
+	return f
+}
+
+func int64Operands(left, right Object) (int64, int64, bool) {
+	l, ok := left.(*Integer)
+	if !ok {
+		return 0, 0, false
+	}
+	r, ok := right.(*Integer)
+	if !ok {
+		return 0, 0, false
+	}
+	return l.Value, r.Value, true
+}
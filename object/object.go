@@ -3,22 +3,40 @@ package object
 import (
 	"bytes"
 	"fmt"
+	"hash/fnv"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/tzcl/monkey/ast"
+	"github.com/tzcl/monkey/code"
+	"github.com/tzcl/monkey/token"
 )
 
 type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	BIGINT_OBJ       = "BIGINT"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
+	STRING_OBJ       = "STRING"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERR_OBJ          = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	QUOTE_OBJ        = "QUOTE"
 	MACRO_OBJ        = "MACRO"
+	ARRAY_OBJ        = "ARRAY"
+	HASH_OBJ         = "HASH"
+	BUILTIN_OBJ      = "BUILTIN"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
+	CELL_OBJ              = "CELL"
 )
 
 type Object interface {
@@ -26,12 +44,68 @@ type Object interface {
 	Inspect() string
 }
 
+// Hashable is implemented by objects that can be used as hash keys.
+type Hashable interface {
+	HashKey() HashKey
+}
+
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
 type Integer struct {
 	Value int64
 }
 
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+// BigInt holds integers too large for an Integer. See NewInteger.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (bi *BigInt) Type() ObjectType { return BIGINT_OBJ }
+func (bi *BigInt) Inspect() string  { return bi.Value.String() }
+func (bi *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write(bi.Value.Bytes())
+
+	// Bytes drops the sign
+	value := h.Sum64()
+	if bi.Value.Sign() < 0 {
+		value = ^value
+	}
+	return HashKey{Type: bi.Type(), Value: value}
+}
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect formats the float as compactly as possible while still telling it
+// apart from an integer, so 2.0 prints as 2.0 rather than 2.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// TRUE, FALSE and NULL are the only booleans and null every engine uses, so
+// they can be compared by identity.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
 
 type Boolean struct {
 	Value bool
@@ -39,6 +113,25 @@ type Boolean struct {
 
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+	return HashKey{Type: b.Type(), Value: value}
+}
+
+type String struct {
+	Value string
+}
+
+func (s *String) Type() ObjectType { return STRING_OBJ }
+func (s *String) Inspect() string  { return s.Value }
+func (s *String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+	return HashKey{Type: s.Type(), Value: h.Sum64()}
+}
 
 type Null struct{}
 
@@ -52,14 +145,46 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break and Continue unwind the statements of a loop body, like ReturnValue
+// does for a function body.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
 type Error struct {
 	Message string
+	Pos     token.Position // where the error was raised, if known
+	Stack   []string       // Monkey call stack, innermost call first, if any
 }
 
 func (e *Error) Type() ObjectType { return ERR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string  { return "ERROR: " + e.Error() }
+
+// Error makes Monkey errors usable as Go errors, for programs embedding the
+// interpreter.
+func (e *Error) Error() string {
+	var out bytes.Buffer
+
+	if e.Pos.IsValid() {
+		out.WriteString(e.Pos.String() + ": ")
+	}
+	out.WriteString(e.Message)
+
+	for _, line := range e.Stack {
+		out.WriteString("\n\t" + line)
+	}
+
+	return out.String()
+}
 
 type Function struct {
+	Name       string // name the function literal was bound to, if any
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -84,6 +209,48 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// CompiledFunction is a function body compiled to bytecode. It only exists as
+// a constant; at runtime it is always wrapped in a Closure.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object // free variables captured when the closure was created
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Cell holds a local variable that is shared by the function defining it and
+// the closures capturing it, so assignments made by one are seen by all.
+// Programs never see cells, only the values in them.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
+
+type BuiltinFunction func(args ...Object) Object
+
+type Builtin struct {
+	Fn BuiltinFunction
+}
+
+func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
+func (b *Builtin) Inspect() string  { return "builtin function" }
+
 type Quote struct {
 	Node ast.Node
 }
@@ -117,3 +284,74 @@ func (m *Macro) Inspect() string {
 
 	return out.String()
 }
+
+type Array struct {
+	Elements []Object
+}
+
+func (a *Array) Type() ObjectType { return ARRAY_OBJ }
+func (a *Array) Inspect() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, e := range a.Elements {
+		elements = append(elements, e.Inspect())
+	}
+
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+type Hash struct {
+	Pairs map[HashKey]HashPair
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+func (h *Hash) Inspect() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair.Key.Inspect()+": "+pair.Value.Inspect())
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Elements returns the values a for loop iterates over: the elements of an
+// array, the characters of a string or the keys of a hash. Hash keys are
+// sorted by their Inspect output so iteration order is stable. It reports
+// false if obj can't be iterated over.
+func Elements(obj Object) ([]Object, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.Elements, true
+	case *String:
+		elements := []Object{}
+		for _, r := range obj.Value {
+			elements = append(elements, &String{Value: string(r)})
+		}
+		return elements, true
+	case *Hash:
+		keys := make([]Object, 0, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			keys = append(keys, pair.Key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Inspect() < keys[j].Inspect() })
+		return keys, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,363 @@
+package object
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestStringHashKey(t *testing.T) {
+	hello1 := &String{Value: "Hello World"}
+	hello2 := &String{Value: "Hello World"}
+	diff1 := &String{Value: "My name is johnny"}
+	diff2 := &String{Value: "My name is johnny"}
+
+	if hello1.HashKey() != hello2.HashKey() {
+		t.Errorf("strings with same content have different hash keys")
+	}
+
+	if diff1.HashKey() != diff2.HashKey() {
+		t.Errorf("strings with same content have different hash keys")
+	}
+
+	if hello1.HashKey() == diff1.HashKey() {
+		t.Errorf("strings with different content have same hash keys")
+	}
+}
+
+func TestIntegerOverflow(t *testing.T) {
+	max := &Integer{Value: math.MaxInt64}
+	min := &Integer{Value: math.MinInt64}
+	one := &Integer{Value: 1}
+	minusOne := &Integer{Value: -1}
+
+	tests := []struct {
+		result   Object
+		expected string
+	}{
+		{AddIntegers(max, one), "9223372036854775808"},
+		{SubIntegers(min, one), "-9223372036854775809"},
+		{MulIntegers(max, max), "85070591730234615847396907784232501249"},
+		{MulIntegers(min, minusOne), "9223372036854775808"},
+		{NegateInteger(min), "9223372036854775808"},
+		// Results that fit are demoted back to an Integer
+		{SubIntegers(AddIntegers(max, one), one), "9223372036854775807"},
+		{AddIntegers(one, minusOne), "0"},
+	}
+
+	for i, tt := range tests {
+		if tt.result.Inspect() != tt.expected {
+			t.Errorf("tests[%d] - wrong result. want=%s, got=%s", i, tt.expected, tt.result.Inspect())
+		}
+
+		_, isBig := tt.result.(*BigInt)
+		fits := ToBigInt(tt.result).IsInt64()
+		if isBig == fits {
+			t.Errorf("tests[%d] - wrong representation for %s. got=%T", i, tt.expected, tt.result)
+		}
+	}
+
+	if _, ok := DivIntegers(one, &Integer{Value: 0}); ok {
+		t.Errorf("division by zero did not fail")
+	}
+
+	quotient, ok := DivIntegers(min, minusOne)
+	if !ok || quotient.Inspect() != "9223372036854775808" {
+		t.Errorf("wrong quotient for MinInt64 / -1. got=%v", quotient)
+	}
+
+	if CompareIntegers(AddIntegers(max, one), max) != 1 {
+		t.Errorf("BigInt did not compare greater than MaxInt64")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{2, "2.0"},
+		{-1500, "-1500.0"},
+		{0.5, "0.5"},
+		{1e-9, "1e-09"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %v. want=%q, got=%q", tt.value, tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestFloatPromotion(t *testing.T) {
+	big := MulIntegers(&Integer{Value: math.MaxInt64}, &Integer{Value: 2})
+
+	tests := []struct {
+		left, right Object
+		promotes    bool
+		leftFloat   float64
+	}{
+		{&Integer{Value: 1}, &Float{Value: 0.5}, true, 1},
+		{&Float{Value: 0.5}, &Integer{Value: 1}, true, 0.5},
+		{big, &Float{Value: 1}, true, 2 * math.MaxInt64},
+		{&Integer{Value: 1}, &Integer{Value: 2}, false, 1},
+		{&Float{Value: 1}, &String{Value: "a"}, false, 1},
+	}
+
+	for _, tt := range tests {
+		if got := PromotesToFloat(tt.left, tt.right); got != tt.promotes {
+			t.Errorf("PromotesToFloat(%s, %s) wrong. want=%t, got=%t",
+				tt.left.Inspect(), tt.right.Inspect(), tt.promotes, got)
+		}
+		if got := ToFloat(tt.left); got != tt.leftFloat {
+			t.Errorf("ToFloat(%s) wrong. want=%g, got=%g", tt.left.Inspect(), tt.leftFloat, got)
+		}
+	}
+}
+
+func TestHashKeyTypesDiffer(t *testing.T) {
+	one := &Integer{Value: 1}
+	yes := &Boolean{Value: true}
+
+	if one.HashKey() == yes.HashKey() {
+		t.Errorf("integer 1 and true have same hash key")
+	}
+}
+
+type testUser struct {
+	Name    string
+	Age     int `monkey:"age"`
+	Tags    []string
+	Secret  string `monkey:"-"`
+	private int
+}
+
+func TestFromGo(t *testing.T) {
+	one := 1
+
+	tests := []struct {
+		value    any
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{int8(-5), "-5"},
+		{uint64(math.MaxUint64), "18446744073709551615"},
+		{2.5, "2.5"},
+		{"hi", "hi"},
+		{&one, "1"},
+		{(*int)(nil), "null"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]bool{true, false}, "[true, false]"},
+		{map[string]int{"a": 1}, `{a: 1}`},
+		{testUser{Name: "ann", Age: 3, Secret: "x"}, `{Name: ann, Tags: [], age: 3}`},
+		{&Integer{Value: 7}, "7"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.value)
+		if err != nil {
+			t.Errorf("FromGo(%#v) returned error: %s", tt.value, err)
+			continue
+		}
+		if inspectSorted(obj) != tt.expected {
+			t.Errorf("FromGo(%#v) wrong. want=%q, got=%q", tt.value, tt.expected, inspectSorted(obj))
+		}
+	}
+
+	if obj, _ := FromGo(false); obj != FALSE {
+		t.Errorf("false did not convert to FALSE")
+	}
+
+	for _, value := range []any{make(chan int), map[[1]int]int{{1}: 1}, func() (int, int) { return 1, 2 }} {
+		if _, err := FromGo(value); err == nil {
+			t.Errorf("FromGo(%T) did not fail", value)
+		}
+	}
+}
+
+func TestFromGoCycles(t *testing.T) {
+	type node struct {
+		Value int
+		Next  *node
+	}
+
+	loop := &node{Value: 1}
+	loop.Next = &node{Value: 2, Next: loop}
+
+	slice := []any{1, nil}
+	slice[1] = slice
+
+	hash := map[string]any{}
+	hash["self"] = hash
+
+	for _, value := range []any{loop, slice, hash} {
+		_, err := FromGo(value)
+		if err == nil || !strings.HasSuffix(err.Error(), "it contains itself") {
+			t.Errorf("FromGo(%T) wrong error. got=%v", value, err)
+		}
+	}
+
+	// Values that are shared without containing themselves are fine
+	shared := &node{Value: 3}
+	obj, err := FromGo([]*node{shared, shared})
+	if err != nil {
+		t.Fatalf("FromGo returned error: %s", err)
+	}
+	if got := inspectSorted(obj.(*Array).Elements[1]); got != "{Next: null, Value: 3}" {
+		t.Errorf("shared value wrong. got=%s", got)
+	}
+}
+
+// inspectSorted is Inspect with the pairs of hashes sorted, for stable output.
+func inspectSorted(obj Object) string {
+	hash, ok := obj.(*Hash)
+	if !ok {
+		return obj.Inspect()
+	}
+
+	pairs := []string{}
+	for _, pair := range hash.Pairs {
+		pairs = append(pairs, inspectSorted(pair.Key)+": "+inspectSorted(pair.Value))
+	}
+	sort.Strings(pairs)
+
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func TestToGo(t *testing.T) {
+	user, _ := FromGo(testUser{Name: "ann", Age: 3, Tags: []string{"a"}})
+
+	var u testUser
+	if err := ToGo(user, &u); err != nil {
+		t.Fatalf("ToGo returned error: %s", err)
+	}
+	if !reflect.DeepEqual(u, testUser{Name: "ann", Age: 3, Tags: []string{"a"}}) {
+		t.Errorf("struct round trip wrong. got=%+v", u)
+	}
+
+	var f float64
+	if err := ToGo(&Integer{Value: 2}, &f); err != nil || f != 2 {
+		t.Errorf("integer to float64 wrong. got=%v (%v)", f, err)
+	}
+
+	var p *int
+	if err := ToGo(NULL, &p); err != nil || p != nil {
+		t.Errorf("null to pointer wrong. got=%v (%v)", p, err)
+	}
+
+	var v any
+	arr, _ := FromGo([]any{1, "a", nil, map[string]bool{"b": true}})
+	if err := ToGo(arr, &v); err != nil {
+		t.Fatalf("ToGo returned error: %s", err)
+	}
+	expected := []any{int64(1), "a", nil, map[any]any{"b": true}}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("array to any wrong. want=%#v, got=%#v", expected, v)
+	}
+
+	var obj Object
+	if err := ToGo(TRUE, &obj); err != nil || obj != TRUE {
+		t.Errorf("object to Object wrong. got=%v (%v)", obj, err)
+	}
+
+	var small int8
+	if err := ToGo(&Integer{Value: 300}, &small); err == nil {
+		t.Errorf("overflowing int8 did not fail")
+	}
+
+	var s string
+	if err := ToGo(&Integer{Value: 1}, &s); err == nil || err.Error() != "cannot convert INTEGER to string" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+
+	if err := ToGo(TRUE, s); err == nil {
+		t.Errorf("non-pointer target did not fail")
+	}
+}
+
+func TestGoFunction(t *testing.T) {
+	tests := []struct {
+		fn       any
+		args     []Object
+		expected string
+	}{
+		{
+			func(a, b int) int { return a + b },
+			[]Object{&Integer{Value: 1}, &Integer{Value: 2}},
+			"3",
+		},
+		{
+			func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+			[]Object{&String{Value: "-"}, &String{Value: "a"}, &String{Value: "b"}},
+			"a-b",
+		},
+		{
+			func(n int) (int, error) {
+				if n < 0 {
+					return 0, errors.New("negative")
+				}
+				return n, nil
+			},
+			[]Object{&Integer{Value: -1}},
+			"ERROR: negative",
+		},
+		{
+			func(obj Object) string { return string(obj.Type()) },
+			[]Object{TRUE},
+			"BOOLEAN",
+		},
+		{
+			func() {},
+			[]Object{},
+			"<nil>",
+		},
+		{
+			func(a int) int { return a },
+			[]Object{},
+			"ERROR: wrong number of arguments. got=0, want=1",
+		},
+		{
+			func(a int) int { return a },
+			[]Object{&String{Value: "x"}},
+			"ERROR: argument 1: cannot convert STRING to int",
+		},
+		{
+			func(xs []int) int { return xs[len(xs)] },
+			[]Object{&Array{}},
+			"ERROR: Go function panicked: runtime error: index out of range [0] with length 0",
+		},
+		{
+			func(args ...Object) Object { panic("boom") },
+			[]Object{},
+			"ERROR: Go function panicked: boom",
+		},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.fn)
+		if err != nil {
+			t.Fatalf("FromGo(%T) returned error: %s", tt.fn, err)
+		}
+		builtin, ok := obj.(*Builtin)
+		if !ok {
+			t.Fatalf("FromGo(%T) is not Builtin. got=%T", tt.fn, obj)
+		}
+
+		result := builtin.Fn(tt.args...)
+		got := "<nil>"
+		if result != nil {
+			got = result.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("calling %T wrong. want=%q, got=%q", tt.fn, tt.expected, got)
+		}
+	}
+}
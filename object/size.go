@@ -0,0 +1,43 @@
+package object
+
+// Sizes are rough estimates of the bytes a value takes up on a 64-bit
+// machine. They only need to be good enough to stop a script building values
+// without bound, not to match what the Go runtime actually allocates.
+
+const (
+	wordSize      = 8
+	interfaceSize = 2 * wordSize
+	sliceSize     = 3 * wordSize
+	stringSize    = 2 * wordSize
+	mapEntrySize  = 6 * wordSize
+)
+
+// SizeOf estimates the memory taken up by obj itself. Objects it refers to,
+// like the elements of an array, aren't included.
+func SizeOf(obj Object) int {
+	switch obj := obj.(type) {
+	case *Integer, *Float:
+		return wordSize
+	case *BigInt:
+		return wordSize + sliceSize + len(obj.Value.Bits())*wordSize
+	case *String:
+		return stringSize + len(obj.Value)
+	case *Array:
+		return sliceSize + len(obj.Elements)*interfaceSize
+	case *Hash:
+		return wordSize + len(obj.Pairs)*mapEntrySize
+	case *Function:
+		return stringSize + sliceSize + 2*wordSize
+	case *Boolean, *Null, *Break, *Continue:
+		// Shared by everything that uses them
+		return 0
+	default:
+		return interfaceSize
+	}
+}
+
+// EnvironmentSize estimates the memory taken up by an environment holding the
+// given number of bindings.
+func EnvironmentSize(bindings int) int {
+	return 4*wordSize + bindings*mapEntrySize
+}
@@ -1,7 +1,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 
 	"github.com/tzcl/monkey/ast"
@@ -14,24 +16,39 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
 	LESSGREATER // < or >
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
 	CALL        // myFunction()
+	INDEX       // array[index]
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
+	token.EQ:     EQUALS,
+	token.NOT_EQ: EQUALS,
+	token.LT:     LESSGREATER,
+	token.GT:     LESSGREATER,
+	token.LT_EQ:  LESSGREATER,
+	token.GT_EQ:  LESSGREATER,
+	token.AND:    AND,
+	token.OR:     OR,
+
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type Parser struct {
@@ -43,6 +60,17 @@ type Parser struct {
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// loopDepth counts the loops enclosing the current token within the
+	// current function, so break and continue can be checked
+	loopDepth int
+
+	// quoteDepth counts the calls to quote enclosing the current token
+	quoteDepth int
+
+	// illegalPos is where the last illegal token reported was, since the
+	// same token can be reported as the peek and again as the current token
+	illegalPos token.Position
 }
 
 type (
@@ -60,6 +88,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
+	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
@@ -68,17 +98,29 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
+	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
+	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
 	return p
 }
@@ -111,7 +153,13 @@ func (p *Parser) Errors() []string {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
+	if p.peekTokenIs(token.ILLEGAL) {
+		p.illegalTokenError(p.peekToken)
+		return
+	}
+
+	msg := fmt.Sprintf("%s: expected next token to be %s, got %s instead",
+		p.peekToken.Pos, t, p.peekToken.Type)
 	p.errors = append(p.errors, msg)
 }
 
@@ -148,7 +196,7 @@ func (p *Parser) parseBoolean() ast.Expression {
 
 	value, err := strconv.ParseBool(p.currToken.Literal)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as bool", p.currToken.Literal)
+		msg := fmt.Sprintf("%s: could not parse %q as bool", p.currToken.Pos, p.currToken.Literal)
 		p.errors = append(p.errors, msg)
 		return nil
 	}
@@ -162,8 +210,26 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.currToken}
 
 	value, err := strconv.ParseInt(p.currToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return p.parseBigIntLiteral()
+	}
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.currToken.Literal)
+		msg := fmt.Sprintf("%s: could not parse %q as integer", p.currToken.Pos, p.currToken.Literal)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseBigIntLiteral() ast.Expression {
+	lit := &ast.BigIntLiteral{Token: p.currToken}
+
+	value, ok := new(big.Int).SetString(p.currToken.Literal, 0)
+	if !ok {
+		msg := fmt.Sprintf("%s: could not parse %q as integer", p.currToken.Pos, p.currToken.Literal)
 		p.errors = append(p.errors, msg)
 		return nil
 	}
@@ -173,12 +239,37 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return lit
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.currToken}
+
+	value, err := strconv.ParseFloat(p.currToken.Literal, 64)
+	if err != nil {
+		msg := fmt.Sprintf("%s: could not parse %q as float", p.currToken.Pos, p.currToken.Literal)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseStringLiteral() ast.Expression {
+	return &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal}
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.currToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControlStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -191,7 +282,7 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 		return nil
 	}
 
-	stmt.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	stmt.Name = p.parseBindingName()
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -201,6 +292,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -222,6 +317,155 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.currToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.currToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	stmt.Variable = p.parseBindingName()
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+
+	errors := len(p.errors)
+	body := p.parseBlockStatement()
+
+	// Parts of a body that didn't parse may be missing
+	if len(p.errors) == errors {
+		p.checkLoopControl(body.Statements)
+	}
+
+	return body
+}
+
+// checkLoopControl reports break and continue statements in a loop body that
+// are part of an expression, like let x = if (c) { break; }. Leaving the loop
+// from there would abandon the expression half evaluated, so they're only
+// allowed as statements of the body and of ifs that are statements too.
+func (p *Parser) checkLoopControl(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.BreakStatement, *ast.ContinueStatement:
+		case *ast.ExpressionStatement:
+			ie, ok := stmt.Expression.(*ast.IfExpression)
+			if !ok {
+				p.checkExpressionLoopControl(stmt)
+				continue
+			}
+
+			p.checkExpressionLoopControl(ie.Condition)
+			p.checkLoopControl(ie.Consequence.Statements)
+			if ie.Alternative != nil {
+				p.checkLoopControl(ie.Alternative.Statements)
+			}
+		default:
+			p.checkExpressionLoopControl(stmt)
+		}
+	}
+}
+
+// checkExpressionLoopControl reports every break and continue in node that
+// would leave the current loop.
+func (p *Parser) checkExpressionLoopControl(node ast.Node) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			return false
+		case *ast.WhileStatement:
+			// The body belongs to the inner loop, which checks it itself
+			p.checkExpressionLoopControl(node.Condition)
+			return false
+		case *ast.ForStatement:
+			p.checkExpressionLoopControl(node.Iterable)
+			return false
+		case *ast.BreakStatement, *ast.ContinueStatement:
+			msg := fmt.Sprintf("%s: %s inside expression", node.Pos(), node.TokenLiteral())
+			p.errors = append(p.errors, msg)
+		}
+		return true
+	})
+}
+
+// parseLoopControlStatement parses break and continue, which are only
+// allowed inside a loop.
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	var stmt ast.Statement
+	if p.currTokenIs(token.BREAK) {
+		stmt = &ast.BreakStatement{Token: p.currToken}
+	} else {
+		stmt = &ast.ContinueStatement{Token: p.currToken}
+	}
+
+	if p.loopDepth == 0 {
+		msg := fmt.Sprintf("%s: %s outside loop", p.currToken.Pos, p.currToken.Literal)
+		p.errors = append(p.errors, msg)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.currToken}
 
@@ -249,6 +493,12 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		p.nextToken()
 	}
 
+	if p.currTokenIs(token.EOF) {
+		msg := fmt.Sprintf("%s: expected next token to be %s, got %s instead",
+			p.currToken.Pos, token.RBRACE, token.EOF)
+		p.errors = append(p.errors, msg)
+	}
+
 	return block
 }
 
@@ -282,7 +532,24 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
+	if t == token.ILLEGAL {
+		p.illegalTokenError(p.currToken)
+		return
+	}
+
+	msg := fmt.Sprintf("%s: no prefix parse function for %s found", p.currToken.Pos, t)
+	p.errors = append(p.errors, msg)
+}
+
+// illegalTokenError reports a token the lexer couldn't make sense of. Its
+// literal says what's wrong with it.
+func (p *Parser) illegalTokenError(t token.Token) {
+	if t.Pos == p.illegalPos {
+		return
+	}
+	p.illegalPos = t.Pos
+
+	msg := fmt.Sprintf("%s: %s", t.Pos, t.Literal)
 	p.errors = append(p.errors, msg)
 }
 
@@ -311,6 +578,35 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	// The target already failed to parse and reported why
+	if left == nil {
+		return nil
+	}
+
+	name, ok := left.(*ast.Identifier)
+	if unquoted := p.unquotedName(left); unquoted != nil {
+		name, ok = unquoted, true
+	}
+	if !ok {
+		msg := fmt.Sprintf("%s: cannot assign to %s", left.Pos(), left.String())
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	expression := &ast.AssignExpression{
+		Token:    p.currToken,
+		Name:     name,
+		Operator: p.currToken.Literal,
+	}
+
+	// Assignment is right associative, so a = b = 1 assigns to both
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1)
+
+	return expression
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken()
 
@@ -369,7 +665,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Body = p.parseBlockStatement()
+	lit.Body = p.parseFunctionBody()
 
 	return lit
 }
@@ -387,11 +683,21 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Body = p.parseBlockStatement()
+	lit.Body = p.parseFunctionBody()
 
 	return lit
 }
 
+// parseFunctionBody parses the body of a function or macro. Loops outside the
+// function don't count, break and continue can't jump out of a function.
+func (p *Parser) parseFunctionBody() *ast.BlockStatement {
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = loopDepth }()
+
+	return p.parseBlockStatement()
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	ids := []*ast.Identifier{}
 
@@ -402,15 +708,13 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 
 	p.nextToken()
 
-	id := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
-	ids = append(ids, id)
+	ids = append(ids, p.parseBindingName())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken() // skip comma
 
-		id := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
-		ids = append(ids, id)
+		ids = append(ids, p.parseBindingName())
 	}
 
 	if !p.expectPeek(token.RPAREN) {
@@ -420,34 +724,120 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	return ids
 }
 
+// parseBindingName parses the name bound by a let, a parameter or a for loop.
+// Inside quote the name can be written as unquote(...), so that macros can
+// bind names they made with gensym.
+func (p *Parser) parseBindingName() *ast.Identifier {
+	ident := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	if p.quoteDepth == 0 || ident.Value != "unquote" || !p.peekTokenIs(token.LPAREN) {
+		return ident
+	}
+
+	p.nextToken()
+	return p.unquotedName(p.parseCallExpression(ident))
+}
+
+// unquotedName returns the name to bind or assign to for exp, if exp is a
+// call to unquote inside quote.
+func (p *Parser) unquotedName(exp ast.Expression) *ast.Identifier {
+	call, ok := exp.(*ast.CallExpression)
+	if !ok || p.quoteDepth == 0 {
+		return nil
+	}
+
+	fn, ok := call.Function.(*ast.Identifier)
+	if !ok || fn.Value != "unquote" {
+		return nil
+	}
+
+	return &ast.Identifier{Token: fn.Token, Value: call.String(), Unquote: call}
+}
+
 func (p *Parser) parseCallExpression(fn ast.Expression) ast.Expression {
+	if fn != nil && fn.TokenLiteral() == "quote" {
+		p.quoteDepth++
+		defer func() { p.quoteDepth-- }()
+	}
+
 	exp := &ast.CallExpression{Token: p.currToken, Function: fn}
-	exp.Arguments = p.parseCallArguments()
+	exp.Arguments = p.parseExpressionList(token.RPAREN)
 	return exp
 }
 
-func (p *Parser) parseCallArguments() []ast.Expression {
-	args := []ast.Expression{}
+func (p *Parser) parseArrayLiteral() ast.Expression {
+	array := &ast.ArrayLiteral{Token: p.currToken}
+	array.Elements = p.parseExpressionList(token.RBRACKET)
+	return array
+}
+
+// parseHashLiteral parses `{key: value, ...}`. Blocks are only parsed where a
+// statement body is expected, so a `{` in expression position is always a hash.
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := &ast.HashLiteral{Token: p.currToken}
+	hash.Pairs = make(map[ast.Expression]ast.Expression)
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
 
-	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return args
+		value := p.parseExpression(LOWEST)
+
+		hash.Pairs[key] = value
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
 	}
 
+	return hash
+}
+
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{Token: p.currToken, Left: left}
+
 	p.nextToken()
-	args = append(args, p.parseExpression(LOWEST))
+	exp.Index = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return exp
+}
+
+// parseExpressionList parses a comma-separated list of expressions up to and
+// including the end token, e.g. call arguments or array elements.
+func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
+	list := []ast.Expression{}
+
+	if p.peekTokenIs(end) {
+		p.nextToken()
+		return list
+	}
+
+	p.nextToken()
+	list = append(list, p.parseExpression(LOWEST))
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		args = append(args, p.parseExpression(LOWEST))
+		list = append(list, p.parseExpression(LOWEST))
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectPeek(end) {
 		return nil
 	}
 
-	return args
+	return list
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
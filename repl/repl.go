@@ -4,45 +4,265 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"os"
+	"strings"
 
+	"github.com/tzcl/monkey/ast"
+	"github.com/tzcl/monkey/compiler"
 	"github.com/tzcl/monkey/evaluator"
 	"github.com/tzcl/monkey/lexer"
 	"github.com/tzcl/monkey/object"
 	"github.com/tzcl/monkey/parser"
+	"github.com/tzcl/monkey/token"
+	"github.com/tzcl/monkey/vm"
 )
 
-const PROMPT = ">> "
+const (
+	PROMPT              = ">> "
+	CONTINUATION_PROMPT = ".. "
+)
+
+const help = `Commands:
+  :env          list the bindings in the global environment
+  :macros       list the defined macros
+  :load FILE    run FILE in the current session
+  :reset        forget all bindings and macros
+  :quit         leave the repl
+  :help         show this message
+
+Input continues over several lines until every (, [, { and /* is closed.
+Enter an empty line to submit incomplete input anyway.
+`
 
-func Start(in io.Reader, out io.Writer) {
+// Engine selects how the REPL runs programs.
+type Engine string
+
+const (
+	EVAL Engine = "eval" // tree-walking evaluator
+	VM   Engine = "vm"   // bytecode compiler and virtual machine
+)
+
+func Start(in io.Reader, out io.Writer, engine Engine) {
 	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
-	macroEnv := object.NewEnvironment()
+	s := newSession(out, engine)
+
+	var input strings.Builder
 
 	for {
-		fmt.Printf(PROMPT)
+		if input.Len() == 0 {
+			fmt.Fprint(out, PROMPT)
+		} else {
+			fmt.Fprint(out, CONTINUATION_PROMPT)
+		}
+
 		scanned := scanner.Scan()
 		if !scanned {
 			return
 		}
 
 		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		if input.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if quit := s.command(strings.TrimSpace(line)); quit {
+				return
+			}
 			continue
 		}
 
-		evaluator.DefineMacros(program, macroEnv)
-		expanded := evaluator.ExpandMacros(program, macroEnv)
+		input.WriteString(line)
+		input.WriteString("\n")
+
+		// An empty line submits whatever we have, so the user can always get
+		// out of a continuation
+		if line != "" && isIncomplete(input.String()) {
+			continue
+		}
+
+		if strings.TrimSpace(input.String()) != "" {
+			s.run("", input.String())
+		}
+		input.Reset()
+	}
+}
+
+// isIncomplete reports whether src has unclosed brackets, an unterminated
+// string or an unterminated block comment, i.e. whether the user is likely to
+// still be typing.
+func isIncomplete(src string) bool {
+	l := lexer.New(src)
+	depth := 0
+
+	for {
+		t := l.NextToken()
+
+		switch t.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		case token.ILLEGAL:
+			if t.Literal == lexer.UnterminatedString || t.Literal == lexer.UnterminatedComment {
+				return true
+			}
+		case token.EOF:
+			return depth > 0
+		}
+	}
+}
+
+// session holds everything that persists between inputs.
+type session struct {
+	out    io.Writer
+	engine Engine
 
-		evaled := evaluator.Eval(expanded, env)
-		if evaled != nil {
-			io.WriteString(out, evaled.Inspect())
-			io.WriteString(out, "\n")
+	env      *object.Environment
+	macroEnv *object.Environment
+
+	// State the VM needs to carry over between inputs
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+}
+
+func newSession(out io.Writer, engine Engine) *session {
+	s := &session{out: out, engine: engine}
+	s.reset()
+	return s
+}
+
+func (s *session) reset() {
+	s.env = object.NewEnvironment()
+	s.macroEnv = object.NewEnvironment()
+
+	s.constants = []object.Object{}
+	s.globals = vm.NewGlobalsStore()
+	s.symbolTable = compiler.NewGlobalSymbolTable()
+}
+
+// command runs a colon command and reports whether the REPL should exit.
+func (s *session) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case ":quit", ":q":
+		return true
+	case ":help":
+		io.WriteString(s.out, help)
+	case ":reset":
+		s.reset()
+	case ":env":
+		s.printEnv()
+	case ":macros":
+		for _, name := range s.macroEnv.Names() {
+			macro, _ := s.macroEnv.Get(name)
+			fmt.Fprintf(s.out, "%s = %s\n", name, macro.Inspect())
+		}
+	case ":load":
+		if arg == "" {
+			io.WriteString(s.out, "usage: :load FILE\n")
+			break
 		}
+
+		src, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintf(s.out, "ERROR: %s\n", err)
+			break
+		}
+		s.run(arg, string(src))
+	default:
+		fmt.Fprintf(s.out, "unknown command %s, try :help\n", name)
+	}
+
+	return false
+}
+
+func (s *session) printEnv() {
+	if s.engine == VM {
+		for _, symbol := range s.symbolTable.Symbols() {
+			// Skip globals whose definition failed at runtime
+			if symbol.Scope != compiler.GlobalScope || s.globals[symbol.Index] == nil {
+				continue
+			}
+			fmt.Fprintf(s.out, "%s = %s\n", symbol.Name, s.globals[symbol.Index].Inspect())
+		}
+		return
+	}
+
+	for _, name := range s.env.Names() {
+		val, _ := s.env.Get(name)
+		fmt.Fprintf(s.out, "%s = %s\n", name, val.Inspect())
+	}
+}
+
+func (s *session) run(filename, src string) {
+	l := lexer.NewFile(filename, src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return
+	}
+
+	evaluator.DefineMacros(program, s.macroEnv)
+	expanded, macroErrors := evaluator.ExpandMacros(program, s.macroEnv)
+	if len(macroErrors) != 0 {
+		for _, err := range macroErrors {
+			fmt.Fprintf(s.out, "\t%s\n", err)
+		}
+		return
+	}
+
+	if s.engine == VM {
+		// Compile against a copy of the symbol table, so input that doesn't
+		// compile can't leave names defined that were never given a value
+		symbolTable := s.symbolTable.Copy()
+		comp := compiler.NewWithState(symbolTable, s.constants)
+		err := comp.Compile(expanded)
+		if err != nil {
+			printParserErrors(s.out, []string{err.Error()})
+			return
+		}
+		s.symbolTable = symbolTable
+
+		code := comp.Bytecode()
+		s.constants = code.Constants
+
+		machine := vm.NewWithGlobalsStore(code, s.globals)
+		err = machine.Run()
+		if err != nil {
+			fmt.Fprintf(s.out, "ERROR: %s\n", err)
+			return
+		}
+
+		// Mirror the evaluator and print nothing after a let
+		if EndsWithValue(program) {
+			io.WriteString(s.out, machine.LastPoppedStackElem().Inspect())
+			io.WriteString(s.out, "\n")
+		}
+		return
+	}
+
+	evaled := evaluator.Eval(expanded, s.env)
+	if evaled != nil {
+		io.WriteString(s.out, evaled.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+}
+
+// EndsWithValue reports whether the last statement of program produces a
+// value. The VM leaves stale values behind after a let or a loop.
+func EndsWithValue(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+
+	switch program.Statements[len(program.Statements)-1].(type) {
+	case *ast.ExpressionStatement, *ast.ReturnStatement:
+		return true
+	default:
+		return false
 	}
 }
 
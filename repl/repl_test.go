@@ -0,0 +1,201 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runRepl(t *testing.T, engine Engine, input string) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out, engine)
+
+	// Drop the prompts so tests only see what was printed
+	result := strings.ReplaceAll(out.String(), PROMPT, "")
+	return strings.ReplaceAll(result, CONTINUATION_PROMPT, "")
+}
+
+func TestMultiLineInput(t *testing.T) {
+	input := `let add = fn(x, y) {
+  x + y
+};
+add(1,
+  2)
+let s = "multi
+line";
+len(s)
+`
+
+	for _, engine := range []Engine{EVAL, VM} {
+		got := runRepl(t, engine, input)
+		if got != "3\n10\n" {
+			t.Errorf("%s: wrong output. got=%q", engine, got)
+		}
+	}
+}
+
+func TestEmptyLineSubmitsIncompleteInput(t *testing.T) {
+	got := runRepl(t, EVAL, "let f = fn() {\n\n1 + 1\n")
+	if !strings.Contains(got, "expected next token") && !strings.Contains(got, "no prefix parse function") {
+		t.Errorf("expected a parser error. got=%q", got)
+	}
+	if !strings.HasSuffix(got, "2\n") {
+		t.Errorf("repl did not recover after error. got=%q", got)
+	}
+}
+
+func TestIsIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 + 2", false},
+		{"fn(x) {", true},
+		{"fn(x) { x }", false},
+		{"[1, 2,", true},
+		{"add(1, [2, {", true},
+		{`"abc`, true},
+		{`"abc"`, false},
+		{`"{"`, false},
+		{"}", false},
+		{"1 /* comment", true},
+		{"1 /* comment */", false},
+		{"1 // comment (", false},
+	}
+
+	for _, tt := range tests {
+		if got := isIncomplete(tt.input); got != tt.expected {
+			t.Errorf("isIncomplete(%q) wrong. want=%t, got=%t", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "lib.monkey")
+	err := os.WriteFile(file, []byte("let double = fn(x) { x * 2 };"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, engine := range []Engine{EVAL, VM} {
+		input := strings.Join([]string{
+			"let b = 2;",
+			"let a = 1;",
+			":env",
+			"let m = macro(x) { x };",
+			":macros",
+			":load " + file,
+			"double(a + b)",
+			":reset",
+			":env",
+			"a",
+			":bogus",
+			":quit",
+			"1 + 1",
+		}, "\n")
+
+		got := runRepl(t, engine, input)
+
+		expected := []string{
+			"a = 1\nb = 2\n",
+			"m = macro(x) {\nx\n}\n",
+			"6\n",
+			"identifier not found: a",
+			"unknown command :bogus",
+		}
+		for _, want := range expected {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: output missing %q. got=%q", engine, want, got)
+			}
+		}
+
+		if strings.Contains(got, "double = ") {
+			t.Errorf("%s: :reset did not clear the environment. got=%q", engine, got)
+		}
+		if strings.HasSuffix(got, "2\n") {
+			t.Errorf("%s: input after :quit was evaluated. got=%q", engine, got)
+		}
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	input := strings.Join([]string{
+		"let m = macro(x) { x };",
+		"m(1, 2)",
+		"let bad = macro() { 1 };",
+		"bad()",
+		"1 + 1",
+	}, "\n")
+
+	for _, engine := range []Engine{EVAL, VM} {
+		got := runRepl(t, engine, input)
+
+		expected := []string{
+			"1:1: cannot expand macro m: wrong number of arguments: want=1, got=2",
+			"1:1: cannot expand macro bad: macro returned INTEGER, not a quoted AST node",
+		}
+		for _, want := range expected {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: output missing %q. got=%q", engine, want, got)
+			}
+		}
+
+		if !strings.HasSuffix(got, "2\n") {
+			t.Errorf("%s: repl did not recover after macro error. got=%q", engine, got)
+		}
+	}
+}
+
+func TestFailedDefinitions(t *testing.T) {
+	input := strings.Join([]string{
+		"let x = 1; missing",
+		"x",
+		`let y = 1 + "a"`,
+		"y",
+		"1 + 1",
+	}, "\n")
+
+	for _, engine := range []Engine{EVAL, VM} {
+		got := runRepl(t, engine, input)
+
+		expected := []string{"identifier not found: missing"}
+		switch engine {
+		case EVAL:
+			// The evaluator ran the first let before failing
+			expected = append(expected, "1\n", "identifier not found: y")
+		case VM:
+			expected = append(expected, "identifier not found: x", "global variable used before it was set")
+		}
+
+		for _, want := range expected {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: output missing %q. got=%q", engine, want, got)
+			}
+		}
+
+		if !strings.HasSuffix(got, "2\n") {
+			t.Errorf("%s: repl did not recover. got=%q", engine, got)
+		}
+	}
+}
+
+func TestPrintsOnlyValues(t *testing.T) {
+	input := strings.Join([]string{
+		"let a = 1",
+		"return a + 1",
+		"for (x in [1]) { x }",
+		"a",
+	}, "\n")
+
+	for _, engine := range []Engine{EVAL, VM} {
+		got := runRepl(t, engine, input)
+		if got != "2\n1\n" {
+			t.Errorf("%s: wrong output. got=%q", engine, got)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package vm
+
+import (
+	"github.com/tzcl/monkey/code"
+	"github.com/tzcl/monkey/object"
+)
+
+// Frame is the activation record of a single closure call.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int // stack pointer before the call, locals live above it
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
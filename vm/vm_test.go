@@ -0,0 +1,524 @@
+package vm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tzcl/monkey/ast"
+	"github.com/tzcl/monkey/compiler"
+	"github.com/tzcl/monkey/evaluator"
+	"github.com/tzcl/monkey/lexer"
+	"github.com/tzcl/monkey/object"
+	"github.com/tzcl/monkey/parser"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1", 1},
+		{"1 + 2", 3},
+		{"1 - 2", -1},
+		{"4 / 2", 2},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"5 * (2 + 10)", 60},
+		{"-50 + 100 + -50", 0},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBigIntegers(t *testing.T) {
+	factorial := "let f = fn(n) { if (n <= 1) { 1 } else { n * f(n - 1) } };"
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"99999999999999999999 + 1", "100000000000000000000"},
+		{"-9223372036854775808 == -9223372036854775807 - 1", "true"},
+		{factorial + "f(25)", "15511210043330985984000000"},
+		{factorial + "f(25) / f(23)", "600"},
+		{factorial + "f(25) > f(24)", "true"},
+		{factorial + "f(24) < f(25)", "true"},
+		{factorial + "-f(25)", "-15511210043330985984000000"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		result := vm.LastPoppedStackElem().Inspect()
+		if result != tt.expected {
+			t.Errorf("%s: wrong result. want=%s, got=%s", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"2.5", 2.5},
+		{"-2.5", -2.5},
+		{"1 + 2.5", 3.5},
+		{"7 / 2.0", 3.5},
+		{"0.5 * 4", 2.0},
+		{"1.5 - 2", -0.5},
+		{"1 == 1.0", true},
+		{"1.5 < 2", true},
+		{"2 >= 2.5", false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 == 1", true},
+		{"1 != 2", true},
+		{"true == false", false},
+		{"(1 < 2) == true", true},
+		{"!5", false},
+		{"!!true", true},
+		{"!(if (false) { 5; })", true},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 2", false},
+		{"2 >= 1", true},
+		{"true && false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"false || 1", true},
+		{"false || false", false},
+		{"1 && 0", true},
+		{"false && [][0][0]", false},
+		{"true || [][0][0]", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 }", Null},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = one + one; one + two", 3},
+		{"let x = 1; let x = x + 1; x", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 5; a = 10; a;", 10},
+		{"let a = 5; a = 10;", 10},
+		{"let a = 1; let b = 2; a = b = 3; a + b;", 6},
+		{"let a = 5; a += 2; a -= 1; a *= 3; a /= 2; a;", 9},
+		{"let a = 1; let f = fn() { a = 2; }; f(); a;", 2},
+		{"let f = fn(a) { a += 1; a * 2 }; f(1);", 4},
+		// Closures share the locals they assign to with their function
+		{"let mk = fn() { let c = 0; fn() { c += 1; c } }; let inc = mk(); inc(); inc(); inc();", 3},
+		{"let mk = fn() { let c = 0; fn() { c += 1; c } }; let a = mk(); let b = mk(); a(); a(); b();", 1},
+		{"let f = fn() { let c = 0; let inc = fn() { c += 1 }; inc(); inc(); c }; f();", 2},
+		{"let f = fn() { let c = 0; let get = fn() { c }; c = 5; get() }; f();", 5},
+		{"let f = fn(n) { let add = fn(x) { n += x }; add(2); add(3); n }; f(1);", 6},
+		{"let f = fn() { let c = 0; fn() { fn() { c += 1 } }()(); c }; f();", 1},
+		{
+			"let f = fn() { let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x *= 10; x }); } fs[0]() + fs[1]() }; f();",
+			30,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 10) { i += 1; }; i;", 10},
+		{"let i = 0; while (true) { i += 1; if (i == 5) { break; } }; i;", 5},
+		{
+			"let i = 0; let sum = 0; while (i < 10) { i += 1; if (i > 3) { continue; } sum += i; }; sum;",
+			6,
+		},
+		{"let sum = 0; for (x in [1, 2, 3]) { sum += x; }; sum;", 6},
+		{`let s = ""; for (c in "abc") { s = c + s; }; s;`, "cba"},
+		{`let s = ""; for (k in {"b": 1, "a": 2}) { s += k; }; s;`, "ab"},
+		{"let sum = 0; for (x in [1, 2, 3]) { if (x == 2) { continue; } sum += x; }; sum;", 4},
+		{
+			"let sum = 0; for (x in [1, 2]) { for (y in [10, 20]) { if (y == 20) { break; } sum += x * y; } }; sum;",
+			30,
+		},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x; } } }; f();", 2},
+		{"let f = fn() { for (x in [1]) {} }; f();", Null},
+		{"let f = fn() { let sum = 0; for (x in [1, 2]) { sum += x; } sum }; f();", 3},
+		{"let f = fn() { let i = 0; while (i < 3) { i += 1; } i }; f();", 3},
+		{"if (true) { for (x in [1]) {} }", Null},
+		{"let i = 0; while (i < 100000) { i += 1; }; i;", 100000},
+		{"let fs = []; for (x in [1, 2, 3]) { fs = push(fs, fn() { x }); }; fs[0]() + fs[2]();", 4},
+		{"let x = 10; for (x in [1, 2]) { let y = x; }; x;", 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStringArrayAndHashExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{"[1 + 2, 3 * 4]", []int{3, 12}},
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][99]", Null},
+		{"{1: 1, 2: 2}[2]", 2},
+		{`{"a": 5}["b"]`, Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTopLevelReturn(t *testing.T) {
+	tests := []vmTestCase{
+		{"return 10; 9;", 10},
+		{"9; return 2 * 5; 9;", 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", 99},
+		{"let noReturn = fn() { }; noReturn();", Null},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{
+			`
+			let globalNum = 10;
+			let sum = fn(a, b) {
+				let c = a + b;
+				c + globalNum;
+			};
+			let outer = fn() { sum(1, 2) + sum(3, 4) + globalNum; };
+			outer() + globalNum;
+			`,
+			50,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len([1, 2, 3])`, 3},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, Null},
+		{`last([1, 2, 3])`, 3},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`push([], 1)`, []int{1}},
+		{`puts("hello")`, Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`
+			let newAdder = fn(a, b) {
+				fn(c) { a + b + c };
+			};
+			let adder = newAdder(1, 2);
+			adder(8);
+			`,
+			11,
+		},
+		{
+			`
+			let newClosure = fn(a, b) {
+				let one = fn() { a; };
+				let two = fn() { b; };
+				fn() { one() + two(); };
+			};
+			let closure = newClosure(9, 90);
+			closure();
+			`,
+			99,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			countDown(1);
+			`,
+			0,
+		},
+		{
+			`
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) {
+						return 0;
+					} else {
+						countDown(x - 1);
+					}
+				};
+				countDown(1);
+			};
+			wrapper();
+			`,
+			0,
+		},
+		{fibonacciInput + "fibonacci(15);", 610},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true;", "type mismatch: INTEGER + BOOLEAN"},
+		{"true + false;", "unknown operator: BOOLEAN + BOOLEAN"},
+		{"-true", "unknown operator: -BOOLEAN"},
+		{`"a" - "b"`, "unknown operator: STRING - STRING"},
+		{"5[0]", "index operator not supported: INTEGER"},
+		{"{[1]: 2}", "unusable as hash key: ARRAY"},
+		{"fn() { 1; }(1);", "wrong number of arguments: want=0, got=1"},
+		{"1()", "not a function: INTEGER"},
+		{"1 / 0", "division by zero"},
+		{"for (x in 1) {}", "cannot iterate over INTEGER"},
+		{"len(1)", "argument to `len` not supported, got INTEGER"},
+		{"let f = fn() { f() }; f();", "stack overflow"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Errorf("expected VM error for %q but resulted in none.", tt.input)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+const fibonacciInput = `
+let fibonacci = fn(x) {
+	if (x == 0) {
+		return 0;
+	} else {
+		if (x == 1) {
+			return 1;
+		} else {
+			fibonacci(x - 1) + fibonacci(x - 2);
+		}
+	}
+};
+`
+
+// The benchmarks compare the VM against the tree-walking evaluator on the
+// same program. Run them with `go test -bench Fibonacci ./vm`.
+
+func BenchmarkFibonacciVM(b *testing.B) {
+	program := parse(fibonacciInput + "fibonacci(20);")
+
+	for i := 0; i < b.N; i++ {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+
+		machine := New(comp.Bytecode())
+		if err := machine.Run(); err != nil {
+			b.Fatalf("vm error: %s", err)
+		}
+	}
+}
+
+func BenchmarkFibonacciEval(b *testing.B) {
+	program := parse(fibonacciInput + "fibonacci(20);")
+
+	for i := 0; i < b.N; i++ {
+		env := object.NewEnvironment()
+		result := evaluator.Eval(program, env)
+		if err, ok := result.(*object.Error); ok {
+			b.Fatalf("evaluator error: %s", err.Inspect())
+		}
+	}
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		stackElem := vm.LastPoppedStackElem()
+
+		testExpectedObject(t, tt.input, tt.expected, stackElem)
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testExpectedObject(t *testing.T, input string, expected interface{}, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		err := testIntegerObject(int64(expected), actual)
+		if err != nil {
+			t.Errorf("%s: testIntegerObject failed: %s", input, err)
+		}
+
+	case float64:
+		float, ok := actual.(*object.Float)
+		if !ok {
+			t.Errorf("%s: object is not Float. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if float.Value != expected {
+			t.Errorf("%s: object has wrong value. got=%g, want=%g", input, float.Value, expected)
+		}
+
+	case bool:
+		err := testBooleanObject(expected, actual)
+		if err != nil {
+			t.Errorf("%s: testBooleanObject failed: %s", input, err)
+		}
+
+	case string:
+		str, ok := actual.(*object.String)
+		if !ok {
+			t.Errorf("%s: object is not String. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if str.Value != expected {
+			t.Errorf("%s: object has wrong value. got=%q, want=%q", input, str.Value, expected)
+		}
+
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("%s: object not Array: %T (%+v)", input, actual, actual)
+			return
+		}
+
+		if len(array.Elements) != len(expected) {
+			t.Errorf("%s: wrong num of elements. want=%d, got=%d",
+				input, len(expected), len(array.Elements))
+			return
+		}
+
+		for i, expectedElem := range expected {
+			err := testIntegerObject(int64(expectedElem), array.Elements[i])
+			if err != nil {
+				t.Errorf("%s: testIntegerObject failed: %s", input, err)
+			}
+		}
+
+	case *object.Null:
+		if actual != Null {
+			t.Errorf("%s: object is not Null: %T (%+v)", input, actual, actual)
+		}
+	}
+}
+
+func testIntegerObject(expected int64, actual object.Object) error {
+	result, ok := actual.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("object is not Integer. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%d, want=%d", result.Value, expected)
+	}
+
+	return nil
+}
+
+func testBooleanObject(expected bool, actual object.Object) error {
+	result, ok := actual.(*object.Boolean)
+	if !ok {
+		return fmt.Errorf("object is not Boolean. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%t, want=%t", result.Value, expected)
+	}
+
+	return nil
+}